@@ -0,0 +1,342 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/capacity"
+)
+
+func quantityString(q *resource.Quantity) string {
+	if q == nil {
+		return ""
+	}
+	return q.String()
+}
+
+// parseQuantity parses an optional quantity of the hub, the error names the offending field
+func parseQuantity(value string, i int, field string) (*resource.Quantity, error) {
+	if value == "" {
+		return nil, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return nil, fmt.Errorf("spec.targets[%d].%s: invalid quantity %q: %v", i, field, value, err)
+	}
+	return &q, nil
+}
+
+// parsePercentage checks an optional percentage of the hub is greater than 0% and at most 100%,
+// the error names the offending field as for quantities
+func parsePercentage(value string, i int, field string) (string, error) {
+	if _, err := capacity.ParsePercent(value); err != nil {
+		return "", fmt.Errorf("spec.targets[%d].%s: invalid quantity %q: %v", i, field, value, err)
+	}
+	return value, nil
+}
+
+// shorthandToHub returns the percentage of a shorthand when one is set, its quantity otherwise
+func shorthandToHub(q *resource.Quantity, percentage string) string {
+	if percentage != "" {
+		return percentage
+	}
+	return quantityString(q)
+}
+
+func capacityStatusToHub(status *ResourceLimiterCapacityStatus) *v1beta2.ResourceLimiterCapacityStatus {
+	if status == nil {
+		return nil
+	}
+	out := &v1beta2.ResourceLimiterCapacityStatus{Nodes: status.Nodes, Allocatable: status.Allocatable.DeepCopy()}
+	for _, resolved := range status.Resolved {
+		out.Resolved = append(out.Resolved, v1beta2.ResourceLimiterResolvedTarget{Target: resolved.Target, Hard: resolved.Hard.DeepCopy()})
+	}
+	return out
+}
+
+func capacityStatusFromHub(status *v1beta2.ResourceLimiterCapacityStatus) *ResourceLimiterCapacityStatus {
+	if status == nil {
+		return nil
+	}
+	out := &ResourceLimiterCapacityStatus{Nodes: status.Nodes, Allocatable: status.Allocatable.DeepCopy()}
+	for _, resolved := range status.Resolved {
+		out.Resolved = append(out.Resolved, ResourceLimiterResolvedTarget{Target: resolved.Target, Hard: resolved.Hard.DeepCopy()})
+	}
+	return out
+}
+
+func boundsToHub(bounds *ResourceBounds) *v1beta2.ResourceBounds {
+	if bounds == nil {
+		return nil
+	}
+	return &v1beta2.ResourceBounds{Min: bounds.Min.DeepCopy(), Max: bounds.Max.DeepCopy()}
+}
+
+func boundsFromHub(bounds *v1beta2.ResourceBounds) *ResourceBounds {
+	if bounds == nil {
+		return nil
+	}
+	return &ResourceBounds{Min: bounds.Min.DeepCopy(), Max: bounds.Max.DeepCopy()}
+}
+
+func policyToHub(policy *ResourcePolicy) *v1beta2.ResourcePolicy {
+	if policy == nil {
+		return nil
+	}
+	return &v1beta2.ResourcePolicy{
+		Container:            boundsToHub(policy.Container),
+		Pod:                  boundsToHub(policy.Pod),
+		MaxLimitRequestRatio: policy.MaxLimitRequestRatio.DeepCopy(),
+	}
+}
+
+func policyFromHub(policy *v1beta2.ResourcePolicy) *ResourcePolicy {
+	if policy == nil {
+		return nil
+	}
+	return &ResourcePolicy{
+		Container:            boundsFromHub(policy.Container),
+		Pod:                  boundsFromHub(policy.Pod),
+		MaxLimitRequestRatio: policy.MaxLimitRequestRatio.DeepCopy(),
+	}
+}
+
+func budgetToHub(budget *ResourceBudget) *v1beta2.ResourceBudget {
+	if budget == nil {
+		return nil
+	}
+	out := &v1beta2.ResourceBudget{Hard: budget.Hard.DeepCopy(), Split: v1beta2.BudgetSplit(budget.Split), WeightKey: budget.WeightKey}
+	if rebalance := budget.Rebalance; rebalance != nil {
+		out.Rebalance = &v1beta2.BudgetRebalance{
+			Interval:      rebalance.Interval,
+			HighWatermark: rebalance.HighWatermark,
+			LowWatermark:  rebalance.LowWatermark,
+			Floor:         rebalance.Floor.DeepCopy(),
+			Ceiling:       rebalance.Ceiling.DeepCopy(),
+			MaxStep:       rebalance.MaxStep.DeepCopy(),
+		}
+	}
+	return out
+}
+
+func budgetFromHub(budget *v1beta2.ResourceBudget) *ResourceBudget {
+	if budget == nil {
+		return nil
+	}
+	out := &ResourceBudget{Hard: budget.Hard.DeepCopy(), Split: BudgetSplit(budget.Split), WeightKey: budget.WeightKey}
+	if rebalance := budget.Rebalance; rebalance != nil {
+		out.Rebalance = &BudgetRebalance{
+			Interval:      rebalance.Interval,
+			HighWatermark: rebalance.HighWatermark,
+			LowWatermark:  rebalance.LowWatermark,
+			Floor:         rebalance.Floor.DeepCopy(),
+			Ceiling:       rebalance.Ceiling.DeepCopy(),
+			MaxStep:       rebalance.MaxStep.DeepCopy(),
+		}
+	}
+	return out
+}
+
+func budgetStatusToHub(status *ResourceLimiterBudgetStatus) *v1beta2.ResourceLimiterBudgetStatus {
+	if status == nil {
+		return nil
+	}
+	out := &v1beta2.ResourceLimiterBudgetStatus{Allocated: status.Allocated.DeepCopy()}
+	for _, share := range status.Shares {
+		out.Shares = append(out.Shares, v1beta2.ResourceLimiterBudgetShare{Namespace: share.Namespace, Hard: share.Hard.DeepCopy()})
+	}
+	out.LastRebalanceTime = status.LastRebalanceTime.DeepCopy()
+	for _, adjustment := range status.Adjustments {
+		out.Adjustments = append(out.Adjustments, v1beta2.ResourceLimiterBudgetAdjustment{
+			Time:      adjustment.Time,
+			Namespace: adjustment.Namespace,
+			Resource:  adjustment.Resource,
+			From:      adjustment.From.DeepCopy(),
+			To:        adjustment.To.DeepCopy(),
+			Used:      adjustment.Used.DeepCopy(),
+		})
+	}
+	return out
+}
+
+func budgetStatusFromHub(status *v1beta2.ResourceLimiterBudgetStatus) *ResourceLimiterBudgetStatus {
+	if status == nil {
+		return nil
+	}
+	out := &ResourceLimiterBudgetStatus{Allocated: status.Allocated.DeepCopy()}
+	for _, share := range status.Shares {
+		out.Shares = append(out.Shares, ResourceLimiterBudgetShare{Namespace: share.Namespace, Hard: share.Hard.DeepCopy()})
+	}
+	out.LastRebalanceTime = status.LastRebalanceTime.DeepCopy()
+	for _, adjustment := range status.Adjustments {
+		out.Adjustments = append(out.Adjustments, ResourceLimiterBudgetAdjustment{
+			Time:      adjustment.Time,
+			Namespace: adjustment.Namespace,
+			Resource:  adjustment.Resource,
+			From:      adjustment.From.DeepCopy(),
+			To:        adjustment.To.DeepCopy(),
+			Used:      adjustment.Used.DeepCopy(),
+		})
+	}
+	return out
+}
+
+func (src *ResourceLimiter) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta2.ResourceLimiter)
+	if !ok {
+		return errors.New("the dst type is wrong")
+	}
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Applied = src.Spec.Applied
+	dst.Spec.Priority = src.Spec.Priority
+	dst.Spec.DriftPolicy = v1beta2.DriftPolicy(src.Spec.DriftPolicy)
+	dst.Spec.Budget = budgetToHub(src.Spec.Budget)
+	dst.Spec.NodeSelector = src.Spec.NodeSelector.DeepCopy()
+	dst.Spec.Quotas = make([]v1beta2.ResourceLimiterQuota, 0, len(src.Spec.Quotas))
+	for _, quota := range src.Spec.Quotas {
+		percentages := ResourcePercentages{}
+		if quota.Percentages != nil {
+			percentages = *quota.Percentages
+		}
+		dst.Spec.Quotas = append(dst.Spec.Quotas, v1beta2.ResourceLimiterQuota{
+			NamespaceName:     quota.NamespaceName,
+			NamespaceSelector: quota.NamespaceSelector.DeepCopy(),
+			CpuRequest:        shorthandToHub(quota.CpuRequest, percentages.CpuRequest),
+			CpuLimit:          shorthandToHub(quota.CpuLimit, percentages.CpuLimit),
+			MemRequest:        shorthandToHub(quota.MemRequest, percentages.MemRequest),
+			MemLimit:          shorthandToHub(quota.MemLimit, percentages.MemLimit),
+			Hard:              quota.Hard.DeepCopy(),
+			LimitRange:        quota.LimitRange.DeepCopy(),
+			ContainerDefaults: quota.ContainerDefaults.DeepCopy(),
+			Policy:            policyToHub(quota.Policy),
+		})
+	}
+
+	dst.Status.State = src.Status.State
+	// The deprecated quotas are not served by v1, the controller reports them again
+	dst.Status.Quotas = []v1beta2.ResourceLimiterQuota{}
+	dst.Status.Namespaces = nil
+	for _, usage := range src.Status.Namespaces {
+		dst.Status.Namespaces = append(dst.Status.Namespaces, v1beta2.ResourceLimiterNamespaceStatus{
+			Namespace:          usage.Namespace,
+			ResourceQuota:      usage.ResourceQuota,
+			Hard:               usage.Hard.DeepCopy(),
+			Used:               usage.Used.DeepCopy(),
+			Remaining:          usage.Remaining.DeepCopy(),
+			UtilizationPercent: copyPercent(usage.UtilizationPercent),
+			Drift:              usage.Drift.DeepCopy(),
+			LimitRange:         usage.LimitRange.DeepCopy(),
+		})
+	}
+	dst.Status.Budget = budgetStatusToHub(src.Status.Budget)
+	dst.Status.Capacity = capacityStatusToHub(src.Status.Capacity)
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Conditions = append([]metav1.Condition(nil), src.Status.Conditions...)
+	dst.Status.Selected = nil
+	for _, selection := range src.Status.Selected {
+		dst.Status.Selected = append(dst.Status.Selected, v1beta2.ResourceLimiterSelection{
+			Target:     selection.Target,
+			Selector:   selection.Selector,
+			Namespaces: append([]string(nil), selection.Namespaces...),
+		})
+	}
+	return nil
+}
+
+// ConvertFrom rejects quotas of the hub whose values are neither valid quantities nor percentages
+func (dst *ResourceLimiter) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta2.ResourceLimiter)
+	if !ok {
+		return errors.New("the src type is wrong")
+	}
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Applied = src.Spec.Applied
+	dst.Spec.Priority = src.Spec.Priority
+	dst.Spec.DriftPolicy = DriftPolicy(src.Spec.DriftPolicy)
+	dst.Spec.Budget = budgetFromHub(src.Spec.Budget)
+	dst.Spec.NodeSelector = src.Spec.NodeSelector.DeepCopy()
+	dst.Spec.Quotas = make([]ResourceLimiterQuota, 0, len(src.Spec.Quotas))
+	for i, quota := range src.Spec.Quotas {
+		newQuota := ResourceLimiterQuota{
+			NamespaceName:     quota.NamespaceName,
+			NamespaceSelector: quota.NamespaceSelector.DeepCopy(),
+			Hard:              quota.Hard.DeepCopy(),
+			LimitRange:        quota.LimitRange.DeepCopy(),
+			ContainerDefaults: quota.ContainerDefaults.DeepCopy(),
+			Policy:            policyFromHub(quota.Policy),
+		}
+		percentages := ResourcePercentages{}
+		for _, shorthand := range []struct {
+			value, field string
+			quantity     **resource.Quantity
+			percentage   *string
+		}{
+			{quota.CpuRequest, "cpu_requests", &newQuota.CpuRequest, &percentages.CpuRequest},
+			{quota.CpuLimit, "cpu_limits", &newQuota.CpuLimit, &percentages.CpuLimit},
+			{quota.MemRequest, "mem_requests", &newQuota.MemRequest, &percentages.MemRequest},
+			{quota.MemLimit, "mem_limits", &newQuota.MemLimit, &percentages.MemLimit},
+		} {
+			var err error
+			if capacity.IsPercent(shorthand.value) {
+				if *shorthand.percentage, err = parsePercentage(shorthand.value, i, shorthand.field); err != nil {
+					return err
+				}
+				continue
+			}
+			if *shorthand.quantity, err = parseQuantity(shorthand.value, i, shorthand.field); err != nil {
+				return err
+			}
+		}
+		if percentages != (ResourcePercentages{}) {
+			newQuota.Percentages = &percentages
+		}
+		dst.Spec.Quotas = append(dst.Spec.Quotas, newQuota)
+	}
+
+	dst.Status.State = src.Status.State
+	dst.Status.Namespaces = nil
+	for _, usage := range src.Status.Namespaces {
+		dst.Status.Namespaces = append(dst.Status.Namespaces, ResourceLimiterNamespaceStatus{
+			Namespace:          usage.Namespace,
+			ResourceQuota:      usage.ResourceQuota,
+			Hard:               usage.Hard.DeepCopy(),
+			Used:               usage.Used.DeepCopy(),
+			Remaining:          usage.Remaining.DeepCopy(),
+			UtilizationPercent: copyPercent(usage.UtilizationPercent),
+			Drift:              usage.Drift.DeepCopy(),
+			LimitRange:         usage.LimitRange.DeepCopy(),
+		})
+	}
+	dst.Status.Budget = budgetStatusFromHub(src.Status.Budget)
+	dst.Status.Capacity = capacityStatusFromHub(src.Status.Capacity)
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Conditions = append([]metav1.Condition(nil), src.Status.Conditions...)
+	dst.Status.Selected = nil
+	for _, selection := range src.Status.Selected {
+		dst.Status.Selected = append(dst.Status.Selected, ResourceLimiterSelection{
+			Target:     selection.Target,
+			Selector:   selection.Selector,
+			Namespaces: append([]string(nil), selection.Namespaces...),
+		})
+	}
+	return nil
+}
+
+func copyPercent(percent map[corev1.ResourceName]int64) map[corev1.ResourceName]int64 {
+	if percent == nil {
+		return nil
+	}
+	out := make(map[corev1.ResourceName]int64, len(percent))
+	for name, value := range percent {
+		out[name] = value
+	}
+	return out
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ResourceLimiterSpec defines the desired state of ResourceLimiter
+type ResourceLimiterSpec struct {
+	Quotas  []ResourceLimiterQuota `json:"targets,omitempty"`
+	Applied bool                   `json:"applied,omitempty"`
+	// Priority decides which ResourceLimiter owns a namespace targeted by several of them:
+	// the highest priority wins, then the oldest, then the first name in alphabetical order.
+	// The others leave the namespace alone and report a QuotaConflict condition.
+	Priority int32 `json:"priority,omitempty"`
+	// DriftPolicy decides what happens to hard limits of a resource quota edited by hand
+	// +kubebuilder:validation:Enum=Enforce;Warn;Adopt
+	// +kubebuilder:default=Enforce
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// Budget divides a total amount of resources across the namespaces of spec.targets,
+	// their shares override the same resources set by the targets
+	Budget *ResourceBudget `json:"budget,omitempty"`
+	// NodeSelector restricts the nodes whose allocatable capacity the percentages of spec.targets are taken of,
+	// every node counts when it is not set
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+// ResourceBudget is the total amount of resources shared by the target namespaces
+type ResourceBudget struct {
+	// Hard is the total of each resource, the shares of the namespaces never add up to more
+	Hard corev1.ResourceList `json:"hard"`
+	// Split decides the share of each namespace: Equal parts, Weighted by the weightKey
+	// label or annotation of the namespace, or proportional to the Usage of its resource quota
+	// +kubebuilder:validation:Enum=Equal;Weighted;Usage
+	// +kubebuilder:default=Equal
+	Split BudgetSplit `json:"split,omitempty"`
+	// WeightKey is the label, or else the annotation, holding the integer weight of a namespace
+	// under the Weighted split, namespaces without it weigh 1
+	WeightKey string `json:"weightKey,omitempty"`
+	// Rebalance periodically moves the unused part of the shares to the namespaces running out of theirs,
+	// the shares are divided by the split only when namespaces join or leave or the budget changes
+	Rebalance *BudgetRebalance `json:"rebalance,omitempty"`
+}
+
+// BudgetRebalance tunes how the shares of a budget follow the usage of the namespaces
+type BudgetRebalance struct {
+	// Interval is the time between two rebalances
+	// +kubebuilder:default="5m"
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// HighWatermark is the percent of its share from which a namespace gets more of the budget
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=90
+	HighWatermark int32 `json:"highWatermark,omitempty"`
+	// LowWatermark is the percent of its share a namespace is brought back to, both when it gives
+	// and when it gets part of the budget
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=50
+	LowWatermark int32 `json:"lowWatermark,omitempty"`
+	// Floor is the smallest share of a namespace by resource
+	Floor corev1.ResourceList `json:"floor,omitempty"`
+	// Ceiling is the largest share of a namespace by resource
+	Ceiling corev1.ResourceList `json:"ceiling,omitempty"`
+	// MaxStep caps the change of the share of a namespace per rebalance by resource
+	MaxStep corev1.ResourceList `json:"maxStep,omitempty"`
+}
+
+// BudgetSplit is how a budget is divided across the namespaces
+type BudgetSplit string
+
+const (
+	// BudgetSplitEqual gives every namespace the same share
+	BudgetSplitEqual BudgetSplit = "Equal"
+	// BudgetSplitWeighted gives every namespace a share proportional to its weight
+	BudgetSplitWeighted BudgetSplit = "Weighted"
+	// BudgetSplitUsage gives every namespace a share proportional to what it uses,
+	// namespaces using nothing weigh as much as the average of the others
+	BudgetSplitUsage BudgetSplit = "Usage"
+)
+
+// DriftPolicy is how the controller treats a hard limit changed outside of the ResourceLimiter
+type DriftPolicy string
+
+const (
+	// DriftPolicyEnforce reverts the live value to the desired one
+	DriftPolicyEnforce DriftPolicy = "Enforce"
+	// DriftPolicyWarn keeps the live value and reports the drift until it is reverted by hand
+	DriftPolicyWarn DriftPolicy = "Warn"
+	// DriftPolicyAdopt keeps the live value and takes it as the applied one, so it is reported once
+	DriftPolicyAdopt DriftPolicy = "Adopt"
+)
+
+// ResourceLimiterQuota is the quota of the namespaces selected by name or labels.
+// Quantities are validated by the API server, malformed values never reach the controller.
+type ResourceLimiterQuota struct {
+	// +kubebuilder:validation:MaxLength=63
+	NamespaceName string `json:"name,omitempty"`
+	// NamespaceSelector applies this quota to every namespace whose labels match,
+	// namespaces created or relabelled later are picked up automatically.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	CpuRequest        *resource.Quantity    `json:"cpu_requests,omitempty"`
+	MemRequest        *resource.Quantity    `json:"mem_requests,omitempty"`
+	CpuLimit          *resource.Quantity    `json:"cpu_limits,omitempty"`
+	MemLimit          *resource.Quantity    `json:"mem_limits,omitempty"`
+	// Percentages sets the cpu and memory shorthands to a percentage of the allocatable capacity
+	// of the nodes matching spec.nodeSelector, they override the quantities above.
+	Percentages *ResourcePercentages `json:"percentages,omitempty"`
+	// Hard caps any resource a ResourceQuota supports, e.g. requests.storage, count/pods or
+	// extended resources. The cpu and memory fields above are shorthands and are overridden by Hard.
+	Hard corev1.ResourceList `json:"hard,omitempty"`
+	// LimitRange defaults and bounds the resources of every container, pod or PVC of the namespace,
+	// it is materialised as the LimitRange rl-limitrange-<namespace>.
+	LimitRange *corev1.LimitRangeSpec `json:"limitRange,omitempty"`
+	// ContainerDefaults fills the requests and limits missing from the containers of the workloads
+	// created in the namespace, it is applied by the mutating webhook.
+	ContainerDefaults *corev1.ResourceRequirements `json:"containerDefaults,omitempty"`
+	// Policy bounds the cpu and memory of every container and pod of the workloads admitted
+	// in the namespace, it is enforced by the validating webhook.
+	Policy *ResourcePolicy `json:"policy,omitempty"`
+}
+
+// ResourcePercentages are the cpu and memory shorthands as percentages, e.g. "15%",
+// they are resolved again when nodes come and go
+type ResourcePercentages struct {
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?%$`
+	CpuRequest string `json:"cpu_requests,omitempty"`
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?%$`
+	MemRequest string `json:"mem_requests,omitempty"`
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?%$`
+	CpuLimit string `json:"cpu_limits,omitempty"`
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?%$`
+	MemLimit string `json:"mem_limits,omitempty"`
+}
+
+// ResourcePolicy bounds the resources a single container or pod may claim
+type ResourcePolicy struct {
+	// Container bounds the requests and limits of each container, init containers included
+	Container *ResourceBounds `json:"container,omitempty"`
+	// Pod bounds the effective requests and limits of each pod
+	Pod *ResourceBounds `json:"pod,omitempty"`
+	// MaxLimitRequestRatio caps the limit divided by the request of each container by resource name
+	MaxLimitRequestRatio corev1.ResourceList `json:"maxLimitRequestRatio,omitempty"`
+}
+
+// ResourceBounds are the minimum and maximum of the requests and limits by resource name
+type ResourceBounds struct {
+	// Min is the lowest request allowed
+	Min corev1.ResourceList `json:"min,omitempty"`
+	// Max is the highest limit allowed
+	Max corev1.ResourceList `json:"max,omitempty"`
+}
+
+// ResourceLimiterNamespaceStatus reports the usage of the resource quota of one namespace
+type ResourceLimiterNamespaceStatus struct {
+	Namespace     string                      `json:"namespace"`
+	ResourceQuota corev1.LocalObjectReference `json:"resourceQuota"`
+	Hard          corev1.ResourceList         `json:"hard,omitempty"`
+	Used          corev1.ResourceList         `json:"used,omitempty"`
+	// Remaining is hard minus used, it is negative when the usage exceeds a lowered hard limit
+	Remaining corev1.ResourceList `json:"remaining,omitempty"`
+	// UtilizationPercent is used divided by hard, rounded to an integer percent
+	UtilizationPercent map[corev1.ResourceName]int64 `json:"utilizationPercent,omitempty"`
+	// Drift holds the desired amounts of the hard limits edited by hand and kept under the Warn policy
+	Drift corev1.ResourceList `json:"drift,omitempty"`
+	// LimitRange refers to the LimitRange of the namespace when the target sets one
+	LimitRange *corev1.LocalObjectReference `json:"limitRange,omitempty"`
+}
+
+// ResourceLimiterStatus defines the observed state of ResourceLimiter
+type ResourceLimiterStatus struct {
+	State string `json:"state"`
+	// Namespaces reports the usage of the resource quota of every target namespace
+	Namespaces []ResourceLimiterNamespaceStatus `json:"namespaces,omitempty"`
+	// Selected lists the namespaces matched by each namespaceSelector in spec.targets
+	Selected []ResourceLimiterSelection `json:"selected,omitempty"`
+	// Budget reports the shares of the namespaces when spec.budget is set
+	Budget *ResourceLimiterBudgetStatus `json:"budget,omitempty"`
+	// Capacity reports what the percentages of spec.targets resolved to
+	Capacity *ResourceLimiterCapacityStatus `json:"capacity,omitempty"`
+	// ObservedGeneration is the generation of the spec this status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are Ready, Reconciling, Degraded, NamespaceMissing and QuotaConflict
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ResourceLimiterBudgetStatus reports how spec.budget is divided
+type ResourceLimiterBudgetStatus struct {
+	// Allocated is the sum of the shares, it never exceeds spec.budget.hard
+	Allocated corev1.ResourceList `json:"allocated,omitempty"`
+	// Shares lists the part of the budget given to each namespace
+	Shares []ResourceLimiterBudgetShare `json:"shares,omitempty"`
+	// LastRebalanceTime is when spec.budget.rebalance last ran
+	LastRebalanceTime *metav1.Time `json:"lastRebalanceTime,omitempty"`
+	// Adjustments are the latest changes of the shares made by the rebalancer, oldest first
+	Adjustments []ResourceLimiterBudgetAdjustment `json:"adjustments,omitempty"`
+}
+
+// ResourceLimiterBudgetAdjustment records one change of a share by the rebalancer
+type ResourceLimiterBudgetAdjustment struct {
+	Time      metav1.Time         `json:"time"`
+	Namespace string              `json:"namespace"`
+	Resource  corev1.ResourceName `json:"resource"`
+	From      resource.Quantity   `json:"from"`
+	To        resource.Quantity   `json:"to"`
+	// Used is what the namespace used when its share changed
+	Used resource.Quantity `json:"used"`
+}
+
+// ResourceLimiterBudgetShare is the part of the budget given to one namespace
+type ResourceLimiterBudgetShare struct {
+	Namespace string              `json:"namespace"`
+	Hard      corev1.ResourceList `json:"hard"`
+}
+
+// ResourceLimiterCapacityStatus reports the allocatable capacity of the nodes matching spec.nodeSelector
+type ResourceLimiterCapacityStatus struct {
+	// Nodes is the number of nodes matching spec.nodeSelector
+	Nodes int `json:"nodes"`
+	// Allocatable is the sum of the allocatable cpu and memory of these nodes
+	Allocatable corev1.ResourceList `json:"allocatable,omitempty"`
+	// Resolved lists the quantities the percentages of each target amount to
+	Resolved []ResourceLimiterResolvedTarget `json:"resolved,omitempty"`
+}
+
+// ResourceLimiterResolvedTarget holds the quantities the percentages of one entry of spec.targets amount to
+type ResourceLimiterResolvedTarget struct {
+	// Target is the index of the entry in spec.targets
+	Target int                 `json:"target"`
+	Hard   corev1.ResourceList `json:"hard"`
+}
+
+// ResourceLimiterSelection records the namespaces a namespaceSelector matched
+type ResourceLimiterSelection struct {
+	// Target is the index of the entry in spec.targets
+	Target     int      `json:"target"`
+	Selector   string   `json:"selector"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// ResourceLimiter is the Schema for the resourcelimiters API
+type ResourceLimiter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ResourceLimiterSpec   `json:"spec,omitempty"`
+	Status ResourceLimiterStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ResourceLimiterList contains a list of ResourceLimiter
+type ResourceLimiterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ResourceLimiter `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ResourceLimiter{}, &ResourceLimiterList{})
+}
@@ -2,7 +2,10 @@ package v1beta1
 
 import (
 	"errors"
+	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	"sigs.k8s.io/controller-runtime/pkg/conversion"
 
 	"github.com/chenliu1993/resourcelimiter/api/v1beta2"
@@ -13,7 +16,24 @@ func (src *ResourceLimiter) ConvertTo(dstRaw conversion.Hub) error {
 	if !ok {
 		return errors.New("the dst type is wroong")
 	}
-	dst.Spec.Quotas = make([]v1beta2.ResourceLimiterQuota, len(src.Spec.Targets))
+	// Types other than the cpu and memory shorthands are carried by the generic hard limits
+	var hard corev1.ResourceList
+	for t, value := range src.Spec.Types {
+		switch t {
+		case "requests.cpu", "limits.cpu", "requests.memory", "limits.memory":
+			continue
+		}
+		quantity, err := k8sresource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid quantity %q of type %s: %v", value, t, err)
+		}
+		if hard == nil {
+			hard = corev1.ResourceList{}
+		}
+		hard[corev1.ResourceName(t)] = quantity
+	}
+
+	dst.Spec.Quotas = make([]v1beta2.ResourceLimiterQuota, 0, len(src.Spec.Targets))
 	for _, ns := range src.Spec.Targets {
 		newQuota := v1beta2.ResourceLimiterQuota{
 			NamespaceName: string(ns),
@@ -21,6 +41,7 @@ func (src *ResourceLimiter) ConvertTo(dstRaw conversion.Hub) error {
 			CpuLimit:      src.Spec.Types[ResourceLimiterType("limits.cpu")],
 			MemRequest:    src.Spec.Types[ResourceLimiterType("requests.memory")],
 			MemLimit:      src.Spec.Types[ResourceLimiterType("limits.memory")],
+			Hard:          hard.DeepCopy(),
 		}
 		dst.Spec.Quotas = append(dst.Spec.Quotas, newQuota)
 	}
@@ -52,6 +73,9 @@ func (dst *ResourceLimiter) ConvertFrom(srcRaw conversion.Hub) error {
 		dst.Spec.Types[ResourceLimiterType("requests.cpu")] = v.CpuRequest
 		dst.Spec.Types[ResourceLimiterType("limits.memory")] = v.MemLimit
 		dst.Spec.Types[ResourceLimiterType("requests.memory")] = v.MemRequest
+		for name, value := range v.Hard {
+			dst.Spec.Types[ResourceLimiterType(name)] = value.String()
+		}
 	}
 
 	return nil
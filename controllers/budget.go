@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+// budgetPlan is how the budget of a ResourceLimiter is divided across its target namespaces
+type budgetPlan struct {
+	budget corev1.ResourceList
+	shares map[string]corev1.ResourceList
+	// growing are the namespaces whose share exceeds their current hard limits,
+	// they are updated once the others shrank
+	growing    map[string]bool
+	namespaces map[string]*corev1.Namespace
+	status     *rlv1beta2.ResourceLimiterBudgetStatus
+	// divided is set when the shares were divided by the split rather than carried over
+	divided bool
+	// adjustments are the changes the rebalancer made to the shares in this reconcile
+	adjustments []rlv1beta2.ResourceLimiterBudgetAdjustment
+}
+
+// hard returns the hard limits of the target with the share of the namespace in place of the budget resources,
+// a namespace without a share, e.g. created after the plan, gets none of the budget
+func (p *budgetPlan) hard(ns string, hard corev1.ResourceList) corev1.ResourceList {
+	out := hard.DeepCopy()
+	if out == nil {
+		out = corev1.ResourceList{}
+	}
+	for name, total := range p.budget {
+		if share, ok := p.shares[ns][name]; ok {
+			out[name] = share.DeepCopy()
+			continue
+		}
+		out[name] = *k8sresource.NewQuantity(0, total.Format)
+	}
+	return out
+}
+
+// order puts the namespaces giving back part of the budget before the ones getting more of it
+func (p *budgetPlan) order(targets []quotaTarget) []quotaTarget {
+	ordered := append([]quotaTarget(nil), targets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !p.growing[ordered[i].namespace] && p.growing[ordered[j].namespace]
+	})
+	return ordered
+}
+
+// budgetScale is the scale a resource is divided at, cpu is shared in millicores and the others in whole units
+func budgetScale(name corev1.ResourceName) inf.Scale {
+	if name == corev1.ResourceCPU || strings.HasSuffix(string(name), "."+string(corev1.ResourceCPU)) {
+		return 3
+	}
+	return 0
+}
+
+// budgetQuantity turns a number of units at the scale back into a quantity
+func budgetQuantity(units *big.Int, scale inf.Scale, format k8sresource.Format) k8sresource.Quantity {
+	return *k8sresource.NewDecimalQuantity(*inf.NewDecBig(units, scale), format)
+}
+
+// budgetUnits returns the quantity as a number of units at the scale, rounded down
+func budgetUnits(quantity k8sresource.Quantity, scale inf.Scale) *big.Int {
+	value := quantity.DeepCopy()
+	return new(inf.Dec).Round(value.AsDec(), scale, inf.RoundFloor).UnscaledBig()
+}
+
+// splitUnits divides the total in proportion to the weights with the largest remainder method,
+// so the parts add up to exactly the total. Zero weights everywhere divide it equally.
+func splitUnits(total *big.Int, weights []*big.Int) []*big.Int {
+	sum := new(big.Int)
+	for _, weight := range weights {
+		sum.Add(sum, weight)
+	}
+	if sum.Sign() == 0 {
+		weights = make([]*big.Int, len(weights))
+		for i := range weights {
+			weights[i] = big.NewInt(1)
+		}
+		sum.SetInt64(int64(len(weights)))
+	}
+
+	parts := make([]*big.Int, len(weights))
+	remainders := make([]*big.Int, len(weights))
+	left := new(big.Int).Set(total)
+	for i, weight := range weights {
+		parts[i], remainders[i] = new(big.Int).QuoRem(new(big.Int).Mul(total, weight), sum, new(big.Int))
+		left.Sub(left, parts[i])
+	}
+	indexes := make([]int, len(weights))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool { return remainders[indexes[i]].Cmp(remainders[indexes[j]]) > 0 })
+	for _, i := range indexes {
+		if left.Sign() <= 0 {
+			break
+		}
+		parts[i].Add(parts[i], big.NewInt(1))
+		left.Sub(left, big.NewInt(1))
+	}
+	return parts
+}
+
+// namespaceWeight reads the weight of a namespace from its label, or else its annotation
+func namespaceWeight(namespace *corev1.Namespace, key string) (*big.Int, error) {
+	value, ok := namespace.Labels[key]
+	if !ok {
+		if value, ok = namespace.Annotations[key]; !ok {
+			return big.NewInt(1), nil
+		}
+	}
+	weight, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || weight < 0 {
+		return nil, fmt.Errorf("namespace %s: weight %s=%q should be a non-negative integer", namespace.Name, key, value)
+	}
+	return big.NewInt(weight), nil
+}
+
+// usageWeights weighs every namespace by its usage of the resource, the ones using nothing
+// weigh as much as the average of the others so that they can start workloads
+func usageWeights(name corev1.ResourceName, scale inf.Scale, quotas []*corev1.ResourceQuota) []*big.Int {
+	weights := make([]*big.Int, len(quotas))
+	sum, users := new(big.Int), int64(0)
+	for i, quota := range quotas {
+		weights[i] = new(big.Int)
+		if quota == nil {
+			continue
+		}
+		if used, ok := quota.Status.Used[name]; ok && used.Sign() > 0 {
+			weights[i] = budgetUnits(used, scale)
+			sum.Add(sum, weights[i])
+			users++
+		}
+	}
+	if users == 0 {
+		return weights
+	}
+	average := sum.Quo(sum, big.NewInt(users))
+	if average.Sign() == 0 {
+		average.SetInt64(1)
+	}
+	for i := range weights {
+		if weights[i].Sign() == 0 {
+			weights[i] = average
+		}
+	}
+	return weights
+}
+
+// ownedQuota returns the resource quota the ResourceLimiter holds in the namespace, nil when there is none
+func (r *ResourceLimiterReconciler) ownedQuota(ctx context.Context, rl *rlv1beta2.ResourceLimiter, ns string) (*corev1.ResourceQuota, error) {
+	resourceQuota := &corev1.ResourceQuota{}
+	if err := r.Get(ctx, k8stypes.NamespacedName{Namespace: ns, Name: fmt.Sprintf("rl-quota-%s", ns)}, resourceQuota); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if resourceQuota.Labels[constants.OwnerLabel] != rl.Name && !metav1.IsControlledBy(resourceQuota, rl) {
+		return nil, nil
+	}
+	return resourceQuota, nil
+}
+
+// planBudget divides spec.budget across the existing target namespaces the ResourceLimiter is not outranked on.
+// Under spec.budget.rebalance the shares in status are kept, and rebalanced when due, as long as the namespaces
+// and the budget stay the same.
+func (r *ResourceLimiterReconciler) planBudget(ctx context.Context, rl *rlv1beta2.ResourceLimiter, targets []quotaTarget, outranked map[string]*rlv1beta2.ResourceLimiter, now metav1.Time) (*budgetPlan, error) {
+	budget := rl.Spec.Budget
+	var (
+		namespaces []*corev1.Namespace
+		quotas     []*corev1.ResourceQuota
+	)
+	for _, target := range targets {
+		if _, ok := outranked[target.namespace]; ok {
+			continue
+		}
+		namespace := &corev1.Namespace{}
+		if err := r.Get(ctx, k8stypes.NamespacedName{Name: target.namespace}, namespace); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if !namespace.DeletionTimestamp.IsZero() {
+			continue
+		}
+		quota, err := r.ownedQuota(ctx, rl, target.namespace)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, namespace)
+		quotas = append(quotas, quota)
+	}
+	sort.Sort(byNamespace{namespaces, quotas})
+
+	plan := &budgetPlan{
+		budget:     budget.Hard,
+		shares:     map[string]corev1.ResourceList{},
+		growing:    map[string]bool{},
+		namespaces: map[string]*corev1.Namespace{},
+		status:     &rlv1beta2.ResourceLimiterBudgetStatus{Allocated: corev1.ResourceList{}},
+	}
+	previous := rl.Status.Budget
+	if budget.Rebalance != nil && reusableShares(previous, budget.Hard, namespaces) {
+		for _, share := range previous.Shares {
+			plan.shares[share.Namespace] = share.Hard.DeepCopy()
+		}
+		plan.status.LastRebalanceTime = previous.LastRebalanceTime
+		plan.status.Adjustments = previous.Adjustments
+		if rebalanceDue(budget.Rebalance, previous.LastRebalanceTime, now) {
+			names := make([]string, 0, len(namespaces))
+			for _, namespace := range namespaces {
+				names = append(names, namespace.Name)
+			}
+			plan.adjustments = rebalanceShares(budget.Rebalance, budget.Hard, names, quotas, plan.shares, now)
+			plan.status.LastRebalanceTime = &now
+			plan.status.Adjustments = append(append([]rlv1beta2.ResourceLimiterBudgetAdjustment(nil), previous.Adjustments...), plan.adjustments...)
+			if len(plan.status.Adjustments) > maxBudgetAdjustments {
+				plan.status.Adjustments = plan.status.Adjustments[len(plan.status.Adjustments)-maxBudgetAdjustments:]
+			}
+		}
+	} else {
+		if err := splitBudget(budget, namespaces, quotas, plan.shares); err != nil {
+			return nil, err
+		}
+		plan.divided = true
+		if budget.Rebalance != nil {
+			// The first rebalance waits for the quotas to report the usage of the new shares
+			plan.status.LastRebalanceTime = &now
+			if previous != nil {
+				plan.status.Adjustments = previous.Adjustments
+			}
+		}
+	}
+
+	for i, namespace := range namespaces {
+		plan.namespaces[namespace.Name] = namespace
+		for name, share := range plan.shares[namespace.Name] {
+			if quotas[i] == nil {
+				plan.growing[namespace.Name] = true
+			} else if current, ok := quotas[i].Spec.Hard[name]; !ok || share.Cmp(current) > 0 {
+				plan.growing[namespace.Name] = true
+			}
+		}
+		plan.status.Shares = append(plan.status.Shares, rlv1beta2.ResourceLimiterBudgetShare{Namespace: namespace.Name, Hard: plan.shares[namespace.Name]})
+	}
+	for name, total := range budget.Hard {
+		scale := budgetScale(name)
+		allocated := new(big.Int)
+		for _, namespace := range namespaces {
+			allocated.Add(allocated, budgetUnits(plan.shares[namespace.Name][name], scale))
+		}
+		plan.status.Allocated[name] = budgetQuantity(allocated, scale, total.Format)
+	}
+	return plan, nil
+}
+
+// splitBudget divides every resource of the budget across the namespaces according to the split
+func splitBudget(budget *rlv1beta2.ResourceBudget, namespaces []*corev1.Namespace, quotas []*corev1.ResourceQuota, shares map[string]corev1.ResourceList) error {
+	var weights []*big.Int
+	if budget.Split == rlv1beta2.BudgetSplitWeighted {
+		for _, namespace := range namespaces {
+			weight, err := namespaceWeight(namespace, budget.WeightKey)
+			if err != nil {
+				return err
+			}
+			weights = append(weights, weight)
+		}
+	}
+	for _, namespace := range namespaces {
+		shares[namespace.Name] = corev1.ResourceList{}
+	}
+	for name, total := range budget.Hard {
+		scale := budgetScale(name)
+		resourceWeights := weights
+		switch {
+		case budget.Split == rlv1beta2.BudgetSplitUsage:
+			resourceWeights = usageWeights(name, scale, quotas)
+		case resourceWeights == nil:
+			resourceWeights = make([]*big.Int, len(namespaces))
+			for i := range resourceWeights {
+				resourceWeights[i] = big.NewInt(1)
+			}
+		}
+		for i, part := range splitUnits(budgetUnits(total, scale), resourceWeights) {
+			shares[namespaces[i].Name][name] = budgetQuantity(part, scale, total.Format)
+		}
+	}
+	return nil
+}
+
+// reusableShares reports whether the shares in status divide the whole budget across the same namespaces,
+// so that the rebalancer carries on from them
+func reusableShares(previous *rlv1beta2.ResourceLimiterBudgetStatus, budget corev1.ResourceList, namespaces []*corev1.Namespace) bool {
+	if previous == nil || len(previous.Shares) != len(namespaces) || len(driftedResources(previous.Allocated, budget)) > 0 {
+		return false
+	}
+	for i, share := range previous.Shares {
+		if share.Namespace != namespaces[i].Name {
+			return false
+		}
+		for name := range budget {
+			if _, ok := share.Hard[name]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// byNamespace sorts the namespaces and their quotas together by name, the shares are then stable across reconciles
+type byNamespace struct {
+	namespaces []*corev1.Namespace
+	quotas     []*corev1.ResourceQuota
+}
+
+func (b byNamespace) Len() int           { return len(b.namespaces) }
+func (b byNamespace) Less(i, j int) bool { return b.namespaces[i].Name < b.namespaces[j].Name }
+func (b byNamespace) Swap(i, j int) {
+	b.namespaces[i], b.namespaces[j] = b.namespaces[j], b.namespaces[i]
+	b.quotas[i], b.quotas[j] = b.quotas[j], b.quotas[i]
+}
+
+// withoutBudget drops the budget resources from the drifted ones, the shares are always enforced
+func withoutBudget(rl *rlv1beta2.ResourceLimiter, names []corev1.ResourceName) []corev1.ResourceName {
+	if rl.Spec.Budget == nil {
+		return names
+	}
+	var kept []corev1.ResourceName
+	for _, name := range names {
+		if _, ok := rl.Spec.Budget.Hard[name]; !ok {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// sharesChanged reports whether the shares differ from the ones in status
+func sharesChanged(previous, current *rlv1beta2.ResourceLimiterBudgetStatus) bool {
+	if previous == nil || len(previous.Shares) != len(current.Shares) {
+		return true
+	}
+	for i, share := range current.Shares {
+		if previous.Shares[i].Namespace != share.Namespace || len(driftedResources(previous.Shares[i].Hard, share.Hard)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func formatShares(shares []rlv1beta2.ResourceLimiterBudgetShare) string {
+	parts := make([]string, 0, len(shares))
+	for _, share := range shares {
+		parts = append(parts, fmt.Sprintf("%s: %s", share.Namespace, formatHard(share.Hard)))
+	}
+	return strings.Join(parts, "; ")
+}
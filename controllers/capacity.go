@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/capacity"
+)
+
+// capacityShorthand is a cpu or memory shorthand of a target, with the allocatable resource of the nodes it may be a percentage of
+type capacityShorthand struct {
+	name        corev1.ResourceName
+	field       string
+	value       *string
+	allocatable corev1.ResourceName
+}
+
+func capacityShorthands(quota *rlv1beta2.ResourceLimiterQuota) []capacityShorthand {
+	return []capacityShorthand{
+		{corev1.ResourceLimitsCPU, "cpu_limits", &quota.CpuLimit, corev1.ResourceCPU},
+		{corev1.ResourceRequestsCPU, "cpu_requests", &quota.CpuRequest, corev1.ResourceCPU},
+		{corev1.ResourceLimitsMemory, "mem_limits", &quota.MemLimit, corev1.ResourceMemory},
+		{corev1.ResourceRequestsMemory, "mem_requests", &quota.MemRequest, corev1.ResourceMemory},
+	}
+}
+
+// usesCapacity reports whether a target sets a shorthand as a percentage of the allocatable capacity of the nodes
+func usesCapacity(rl *rlv1beta2.ResourceLimiter) bool {
+	for i := range rl.Spec.Quotas {
+		for _, shorthand := range capacityShorthands(&rl.Spec.Quotas[i]) {
+			if capacity.IsPercent(*shorthand.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveCapacity replaces the percentages of the targets by what they amount to on the nodes matching spec.nodeSelector
+// and reports the allocatable capacity they were taken of, the status is nil when no target sets a percentage
+func (r *ResourceLimiterReconciler) resolveCapacity(ctx context.Context, rl *rlv1beta2.ResourceLimiter, targets []quotaTarget) (*rlv1beta2.ResourceLimiterCapacityStatus, error) {
+	if !usesCapacity(rl) {
+		return nil, nil
+	}
+	selector := labels.Everything()
+	if rl.Spec.NodeSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(rl.Spec.NodeSelector); err != nil {
+			return nil, fmt.Errorf("invalid nodeSelector: %v", err)
+		}
+	}
+	nodes := corev1.NodeList{}
+	if err := r.List(ctx, &nodes, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	if len(nodes.Items) == 0 {
+		return nil, fmt.Errorf("no node matches the nodeSelector, the percentages of spec.targets cannot be resolved")
+	}
+
+	status := &rlv1beta2.ResourceLimiterCapacityStatus{Nodes: len(nodes.Items), Allocatable: capacity.Allocatable(nodes.Items)}
+	resolved := map[int]rlv1beta2.ResourceLimiterQuota{}
+	for i, quota := range rl.Spec.Quotas {
+		hard := corev1.ResourceList{}
+		for _, shorthand := range capacityShorthands(&quota) {
+			if !capacity.IsPercent(*shorthand.value) {
+				continue
+			}
+			percent, err := capacity.ParsePercent(*shorthand.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s of targets[%d]: %v", shorthand.field, i, err)
+			}
+			value := capacity.Resolve(percent, status.Allocatable[shorthand.allocatable], shorthand.allocatable)
+			hard[shorthand.name] = value
+			*shorthand.value = value.String()
+		}
+		if len(hard) != 0 {
+			resolved[i] = quota
+			status.Resolved = append(status.Resolved, rlv1beta2.ResourceLimiterResolvedTarget{Target: i, Hard: hard})
+		}
+	}
+	for i := range targets {
+		if quota, ok := resolved[targets[i].index]; ok {
+			targets[i].quota = quota
+		}
+	}
+	return status, nil
+}
+
+// nodePredicate only lets through the nodes that join, leave, or change their allocatable capacity or labels,
+// so that the heartbeats of the kubelets do not reconcile every ResourceLimiter
+func nodePredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldNode.Status.Allocatable, newNode.Status.Allocatable) ||
+				!equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// nodeToLimiters maps a node event to the ResourceLimiters whose targets set a percentage of the allocatable capacity
+func (r *ResourceLimiterReconciler) nodeToLimiters(obj client.Object) []reconcile.Request {
+	rls := rlv1beta2.ResourceLimiterList{}
+	if err := r.List(context.Background(), &rls); err != nil {
+		ctrl.Log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to list resourcelimiters for node %s", obj.GetName()))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range rls.Items {
+		if usesCapacity(&rls.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: k8stypes.NamespacedName{Name: rls.Items[i].Name}})
+		}
+	}
+	return requests
+}
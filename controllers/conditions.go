@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+// namespaceFailure records why the quota of one namespace could not be reconciled,
+// an empty namespace means the failure concerns the whole ResourceLimiter
+type namespaceFailure struct {
+	namespace string
+	reason    string
+	err       error
+}
+
+func (f namespaceFailure) message() string {
+	if f.namespace == "" {
+		return fmt.Sprintf("%s: %v", f.reason, f.err)
+	}
+	return fmt.Sprintf("namespace %s: %s: %v", f.namespace, f.reason, f.err)
+}
+
+func joinFailures(failures []namespaceFailure) string {
+	messages := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		messages = append(messages, failure.message())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// isConflict reports whether the namespace was left to a higher ranked ResourceLimiter, which is not retried
+func (f namespaceFailure) isConflict() bool {
+	return f.reason == constants.ReasonOutranked
+}
+
+// setConditions derives the standard conditions of the status from the failures of a reconcile
+func setConditions(status *rlv1beta2.ResourceLimiterStatus, generation int64, failures []namespaceFailure) {
+	var missing, degraded, conflicts, retried []namespaceFailure
+	for _, failure := range failures {
+		switch {
+		case failure.isConflict():
+			conflicts = append(conflicts, failure)
+			continue
+		case failure.reason == constants.ReasonNamespaceNotFound:
+			missing = append(missing, failure)
+		default:
+			degraded = append(degraded, failure)
+		}
+		retried = append(retried, failure)
+	}
+
+	set := func(conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			ObservedGeneration: generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+
+	if len(missing) > 0 {
+		set(constants.ConditionNamespaceMissing, metav1.ConditionTrue, constants.ReasonNamespaceNotFound, joinFailures(missing))
+	} else {
+		set(constants.ConditionNamespaceMissing, metav1.ConditionFalse, constants.ReasonAllNamespacesFound, "all target namespaces exist")
+	}
+
+	if len(degraded) > 0 {
+		set(constants.ConditionDegraded, metav1.ConditionTrue, degraded[0].reason, joinFailures(degraded))
+	} else {
+		set(constants.ConditionDegraded, metav1.ConditionFalse, constants.ReasonReconcileComplete, "")
+	}
+
+	if len(conflicts) > 0 {
+		set(constants.ConditionQuotaConflict, metav1.ConditionTrue, constants.ReasonOutranked, joinFailures(conflicts))
+	} else {
+		set(constants.ConditionQuotaConflict, metav1.ConditionFalse, constants.ReasonNoConflict, "")
+	}
+
+	if len(retried) > 0 {
+		set(constants.ConditionReconciling, metav1.ConditionTrue, constants.ReasonRetrying, fmt.Sprintf("%d namespace(s) failed to reconcile", len(retried)))
+		set(constants.ConditionReady, metav1.ConditionFalse, constants.ReasonReconcileFailed, joinFailures(retried))
+		return
+	}
+	set(constants.ConditionReconciling, metav1.ConditionFalse, constants.ReasonReconcileComplete, "")
+	if status.State == constants.Stopped {
+		set(constants.ConditionReady, metav1.ConditionTrue, constants.ReasonQuotasRemoved, "quotas are removed from the target namespaces")
+		return
+	}
+	set(constants.ConditionReady, metav1.ConditionTrue, constants.ReasonQuotasApplied, "quotas are applied to the target namespaces")
+}
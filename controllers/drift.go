@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+// lastAppliedHard returns the hard limits the controller wrote last time,
+// quotas written before the annotation existed take their live limits as applied
+func lastAppliedHard(resourceQuota *corev1.ResourceQuota) corev1.ResourceList {
+	value, ok := resourceQuota.Annotations[constants.LastAppliedHardAnnotation]
+	if !ok {
+		return resourceQuota.Spec.Hard.DeepCopy()
+	}
+	applied := corev1.ResourceList{}
+	if err := json.Unmarshal([]byte(value), &applied); err != nil {
+		return resourceQuota.Spec.Hard.DeepCopy()
+	}
+	return applied
+}
+
+func setLastAppliedHard(resourceQuota *corev1.ResourceQuota, applied corev1.ResourceList) error {
+	value, err := json.Marshal(applied)
+	if err != nil {
+		return err
+	}
+	if resourceQuota.Annotations == nil {
+		resourceQuota.Annotations = map[string]string{}
+	}
+	resourceQuota.Annotations[constants.LastAppliedHardAnnotation] = string(value)
+	return nil
+}
+
+// driftedResources returns the resources whose live hard limit differs from the applied one
+func driftedResources(live, applied corev1.ResourceList) []corev1.ResourceName {
+	var drifted []corev1.ResourceName
+	for name, value := range live {
+		if appliedValue, ok := applied[name]; !ok || value.Cmp(appliedValue) != 0 {
+			drifted = append(drifted, name)
+		}
+	}
+	for name := range applied {
+		if _, ok := live[name]; !ok {
+			drifted = append(drifted, name)
+		}
+	}
+	sort.Slice(drifted, func(i, j int) bool { return drifted[i] < drifted[j] })
+	return drifted
+}
+
+// resolveDrift returns the hard limits to write and to record as applied under the policy.
+// Enforce writes the desired limits, Warn and Adopt keep the drifted live limits,
+// Adopt also records them as applied so they are no longer reported.
+func resolveDrift(policy rlv1beta2.DriftPolicy, live, applied, desired corev1.ResourceList, drifted []corev1.ResourceName) (corev1.ResourceList, corev1.ResourceList) {
+	if policy != rlv1beta2.DriftPolicyWarn && policy != rlv1beta2.DriftPolicyAdopt {
+		return desired.DeepCopy(), desired.DeepCopy()
+	}
+
+	hard, newApplied := desired.DeepCopy(), desired.DeepCopy()
+	for _, name := range drifted {
+		delete(hard, name)
+		delete(newApplied, name)
+		if value, ok := live[name]; ok {
+			hard[name] = value.DeepCopy()
+		}
+		if policy == rlv1beta2.DriftPolicyAdopt {
+			if value, ok := live[name]; ok {
+				newApplied[name] = value.DeepCopy()
+			}
+		} else if value, ok := applied[name]; ok {
+			newApplied[name] = value.DeepCopy()
+		}
+	}
+	return hard, newApplied
+}
+
+func formatDrift(live, desired corev1.ResourceList, drifted []corev1.ResourceName) string {
+	diffs := make([]string, 0, len(drifted))
+	for _, name := range drifted {
+		liveValue, desiredValue := "<none>", "<none>"
+		if value, ok := live[name]; ok {
+			liveValue = value.String()
+		}
+		if value, ok := desired[name]; ok {
+			desiredValue = value.String()
+		}
+		diffs = append(diffs, fmt.Sprintf("%s is %s, desired %s", name, liveValue, desiredValue))
+	}
+	return strings.Join(diffs, ", ")
+}
+
+func formatHard(hard corev1.ResourceList) string {
+	names := make([]string, 0, len(hard))
+	for name := range hard {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	limits := make([]string, 0, len(names))
+	for _, name := range names {
+		value := hard[corev1.ResourceName(name)]
+		limits = append(limits, fmt.Sprintf("%s=%s", name, value.String()))
+	}
+	return strings.Join(limits, ",")
+}
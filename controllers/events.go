@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+)
+
+// recordEvent records the event on the ResourceLimiter and, when known, on the target namespace,
+// so that namespace owners see it in `kubectl describe namespace`
+func (r *ResourceLimiterReconciler) recordEvent(rl *rlv1beta2.ResourceLimiter, namespace *corev1.Namespace, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(rl, eventType, reason, message)
+	if namespace != nil && namespace.UID != "" {
+		r.Recorder.Eventf(namespace, eventType, reason, "%s (resourcelimiter %s)", message, rl.Name)
+	}
+}
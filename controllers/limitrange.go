@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+func limitRangeName(ns string) string {
+	return fmt.Sprintf("rl-limitrange-%s", ns)
+}
+
+// reconcileLimitRange creates or updates the LimitRange of the namespace when the target sets one,
+// and deletes it otherwise. It only runs for applied ResourceLimiters, the others release their LimitRanges.
+func (r *ResourceLimiterReconciler) reconcileLimitRange(ctx context.Context, rl *rlv1beta2.ResourceLimiter, namespace *corev1.Namespace, quota rlv1beta2.ResourceLimiterQuota) (*corev1.LocalObjectReference, error) {
+	log := ctrl.LoggerFrom(ctx)
+	if quota.LimitRange == nil {
+		return nil, r.deleteLimitRange(ctx, rl, namespace, "the target sets no limitRange")
+	}
+
+	limitRange := &corev1.LimitRange{}
+	name := limitRangeName(namespace.Name)
+	if err := r.Get(ctx, k8stypes.NamespacedName{Namespace: namespace.Name, Name: name}, limitRange); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("create limit range %s", name))
+		limitRange.Name = name
+		limitRange.Namespace = namespace.Name
+		limitRange.Labels = map[string]string{constants.OwnerLabel: rl.Name}
+		if err := controllerutil.SetControllerReference(rl, limitRange, r.Scheme); err != nil {
+			return nil, err
+		}
+		limitRange.Spec = *quota.LimitRange.DeepCopy()
+		if err := r.Create(ctx, limitRange); err != nil {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("create the limit range %s failed", name))
+			return nil, err
+		}
+		r.recordEvent(rl, namespace, corev1.EventTypeNormal, constants.EventReasonLimitRangeCreated, fmt.Sprintf("limit range %s created", name))
+		return &corev1.LocalObjectReference{Name: name}, nil
+	}
+
+	if metav1.IsControlledBy(limitRange, rl) && limitRange.Labels[constants.OwnerLabel] == rl.Name &&
+		equality.Semantic.DeepEqual(limitRange.Spec, *quota.LimitRange) {
+		return &corev1.LocalObjectReference{Name: name}, nil
+	}
+	if !metav1.IsControlledBy(limitRange, rl) {
+		// Take over the limit range from a ResourceLimiter this one outranks
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("take over limit range %s", name))
+	}
+	ownerReferences := []metav1.OwnerReference{}
+	for _, ref := range limitRange.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			ownerReferences = append(ownerReferences, ref)
+		}
+	}
+	limitRange.OwnerReferences = ownerReferences
+	if err := controllerutil.SetControllerReference(rl, limitRange, r.Scheme); err != nil {
+		return nil, err
+	}
+	if limitRange.Labels == nil {
+		limitRange.Labels = map[string]string{}
+	}
+	limitRange.Labels[constants.OwnerLabel] = rl.Name
+	limitRange.Spec = *quota.LimitRange.DeepCopy()
+	if err := r.Update(ctx, limitRange); err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("update the limit range %s failed", name))
+		return nil, err
+	}
+	r.recordEvent(rl, namespace, corev1.EventTypeNormal, constants.EventReasonLimitRangeUpdated, fmt.Sprintf("limit range %s updated", name))
+	return &corev1.LocalObjectReference{Name: name}, nil
+}
+
+// deleteLimitRange deletes the LimitRange of the namespace if the ResourceLimiter owns it
+func (r *ResourceLimiterReconciler) deleteLimitRange(ctx context.Context, rl *rlv1beta2.ResourceLimiter, namespace *corev1.Namespace, reason string) error {
+	limitRange := &corev1.LimitRange{}
+	if err := r.Get(ctx, k8stypes.NamespacedName{Namespace: namespace.Name, Name: limitRangeName(namespace.Name)}, limitRange); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if limitRange.Labels[constants.OwnerLabel] != rl.Name && !metav1.IsControlledBy(limitRange, rl) {
+		// The limit range belongs to another ResourceLimiter
+		return nil
+	}
+	if err := r.Delete(ctx, limitRange); err != nil && !apierrors.IsNotFound(err) {
+		ctrl.LoggerFrom(ctx).WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to delete limit range %s", limitRange.Name))
+		return err
+	}
+	r.recordEvent(rl, namespace, corev1.EventTypeNormal, constants.EventReasonLimitRangeDeleted, fmt.Sprintf("limit range %s deleted as %s", limitRange.Name, reason))
+	return nil
+}
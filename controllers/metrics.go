@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+)
+
+// Results of a reconcile counted by reconcileTotal
+const (
+	reconcileSuccess  = "success"
+	reconcileDegraded = "degraded"
+	reconcileError    = "error"
+)
+
+var (
+	quotaDriftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resourcelimiter_quota_drift_total",
+		Help: "Number of times a resource quota was found edited outside of its ResourceLimiter.",
+	}, []string{"resourcelimiter", "namespace", "policy"})
+
+	quotaHard = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resourcelimiter_quota_hard",
+		Help: "Hard limit of a resource in the resource quota of a namespace.",
+	}, []string{"resourcelimiter", "namespace", "resource"})
+	quotaUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resourcelimiter_quota_used",
+		Help: "Used amount of a resource in the resource quota of a namespace.",
+	}, []string{"resourcelimiter", "namespace", "resource"})
+	quotaRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resourcelimiter_quota_remaining",
+		Help: "Remaining amount of a resource in the resource quota of a namespace, negative when over the limit.",
+	}, []string{"resourcelimiter", "namespace", "resource"})
+
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resourcelimiter_reconcile_total",
+		Help: "Number of reconciles of a ResourceLimiter by result: success, degraded (some namespaces failed) or error.",
+	}, []string{"resourcelimiter", "result"})
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "resourcelimiter_reconcile_duration_seconds",
+		Help:    "Duration of the reconciles of a ResourceLimiter.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"resourcelimiter"})
+
+	driftedNamespaces = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resourcelimiter_drifted_namespaces",
+		Help: "Number of namespaces whose resource quota keeps hand edited hard limits.",
+	}, []string{"resourcelimiter"})
+	conflictingNamespaces = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resourcelimiter_conflicting_namespaces",
+		Help: "Number of target namespaces left to a higher ranked ResourceLimiter.",
+	}, []string{"resourcelimiter"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		quotaDriftTotal,
+		quotaHard,
+		quotaUsed,
+		quotaRemaining,
+		reconcileTotal,
+		reconcileDuration,
+		driftedNamespaces,
+		conflictingNamespaces,
+	)
+}
+
+// usageSeries remembers the namespace and resource labels reported for every ResourceLimiter,
+// so that the series of namespaces or resources which went away can be deleted
+var usageSeries = struct {
+	sync.Mutex
+	labels map[string]map[[2]string]bool
+}{labels: map[string]map[[2]string]bool{}}
+
+// recordMetrics exports the usage of the namespaces and the outcome of a reconcile
+func recordMetrics(rl *rlv1beta2.ResourceLimiter, usages []rlv1beta2.ResourceLimiterNamespaceStatus, failures []namespaceFailure) {
+	usageSeries.Lock()
+	defer usageSeries.Unlock()
+
+	current := map[[2]string]bool{}
+	drifted := 0
+	for _, usage := range usages {
+		if len(usage.Drift) > 0 {
+			drifted++
+		}
+		for name, hard := range usage.Hard {
+			used, remaining := usage.Used[name], usage.Remaining[name]
+			quotaHard.WithLabelValues(rl.Name, usage.Namespace, string(name)).Set(hard.AsApproximateFloat64())
+			quotaUsed.WithLabelValues(rl.Name, usage.Namespace, string(name)).Set(used.AsApproximateFloat64())
+			quotaRemaining.WithLabelValues(rl.Name, usage.Namespace, string(name)).Set(remaining.AsApproximateFloat64())
+			current[[2]string{usage.Namespace, string(name)}] = true
+		}
+	}
+	for series := range usageSeries.labels[rl.Name] {
+		if !current[series] {
+			deleteUsageSeries(rl.Name, series)
+		}
+	}
+	usageSeries.labels[rl.Name] = current
+
+	conflicts, retried := 0, 0
+	for _, failure := range failures {
+		if failure.isConflict() {
+			conflicts++
+		} else {
+			retried++
+		}
+	}
+	driftedNamespaces.WithLabelValues(rl.Name).Set(float64(drifted))
+	conflictingNamespaces.WithLabelValues(rl.Name).Set(float64(conflicts))
+	if retried > 0 {
+		reconcileTotal.WithLabelValues(rl.Name, reconcileDegraded).Inc()
+	} else {
+		reconcileTotal.WithLabelValues(rl.Name, reconcileSuccess).Inc()
+	}
+}
+
+// forgetMetrics deletes the gauges of a deleted ResourceLimiter
+func forgetMetrics(rl *rlv1beta2.ResourceLimiter) {
+	usageSeries.Lock()
+	defer usageSeries.Unlock()
+
+	for series := range usageSeries.labels[rl.Name] {
+		deleteUsageSeries(rl.Name, series)
+	}
+	delete(usageSeries.labels, rl.Name)
+	driftedNamespaces.DeleteLabelValues(rl.Name)
+	conflictingNamespaces.DeleteLabelValues(rl.Name)
+}
+
+func deleteUsageSeries(name string, series [2]string) {
+	labels := []string{name, series[0], series[1]}
+	quotaHard.DeleteLabelValues(labels...)
+	quotaUsed.DeleteLabelValues(labels...)
+	quotaRemaining.DeleteLabelValues(labels...)
+}
@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/conflict"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+// quotaTarget is one namespace a ResourceLimiterQuota entry resolves to
+type quotaTarget struct {
+	namespace string
+	// index is the position of the entry in spec.targets
+	index int
+	quota rlv1beta2.ResourceLimiterQuota
+}
+
+func isIgnoredNamespace(name string) bool {
+	return name == string(constants.IgnoreKubeSystem) || name == string(constants.IgnoreKubePublic)
+}
+
+// resolveTargets expands spec.targets into one quotaTarget per namespace.
+// Explicit names are kept even if the namespace does not exist, so that the caller can report it,
+// and a namespace matched by several entries is served by the first one.
+func (r *ResourceLimiterReconciler) resolveTargets(ctx context.Context, rl *rlv1beta2.ResourceLimiter) ([]quotaTarget, []rlv1beta2.ResourceLimiterSelection, error) {
+	var (
+		targets   []quotaTarget
+		selected  []rlv1beta2.ResourceLimiterSelection
+		seen      = map[string]bool{}
+		addTarget = func(ns string, index int, quota rlv1beta2.ResourceLimiterQuota) {
+			if isIgnoredNamespace(ns) || seen[ns] {
+				return
+			}
+			seen[ns] = true
+			targets = append(targets, quotaTarget{namespace: ns, index: index, quota: quota})
+		}
+	)
+
+	for i, quota := range rl.Spec.Quotas {
+		if quota.NamespaceName != "" {
+			addTarget(quota.NamespaceName, i, quota)
+		}
+		if quota.NamespaceSelector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(quota.NamespaceSelector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid namespaceSelector in targets[%d]: %v", i, err)
+		}
+		namespaces := corev1.NamespaceList{}
+		if err := r.List(ctx, &namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, nil, err
+		}
+
+		selection := rlv1beta2.ResourceLimiterSelection{
+			Target:   i,
+			Selector: metav1.FormatLabelSelector(quota.NamespaceSelector),
+		}
+		for _, ns := range namespaces.Items {
+			if isIgnoredNamespace(ns.Name) || !ns.DeletionTimestamp.IsZero() {
+				continue
+			}
+			selection.Namespaces = append(selection.Namespaces, ns.Name)
+			addTarget(ns.Name, i, quota)
+		}
+		sort.Strings(selection.Namespaces)
+		selected = append(selected, selection)
+	}
+
+	return targets, selected, nil
+}
+
+// deselectedNamespaces returns the namespaces recorded in status as selected that are no longer targeted
+func deselectedNamespaces(rl *rlv1beta2.ResourceLimiter, targets []quotaTarget) []string {
+	current := map[string]bool{}
+	for _, target := range targets {
+		current[target.namespace] = true
+	}
+
+	var deselected []string
+	for _, selection := range rl.Status.Selected {
+		for _, ns := range selection.Namespaces {
+			if !current[ns] {
+				current[ns] = true
+				deselected = append(deselected, ns)
+			}
+		}
+	}
+	return deselected
+}
+
+// orphanedNamespaces returns the namespaces holding a resource quota or limit range of the ResourceLimiter that are no longer targeted.
+// Quotas are found by the owner label, or by the controller reference for quotas created before the label existed.
+func (r *ResourceLimiterReconciler) orphanedNamespaces(ctx context.Context, rl *rlv1beta2.ResourceLimiter, targets []quotaTarget) ([]string, error) {
+	current := map[string]bool{}
+	for _, target := range targets {
+		current[target.namespace] = true
+	}
+
+	owner := client.MatchingLabels{constants.OwnerLabel: rl.Name}
+	quotas := corev1.ResourceQuotaList{}
+	if err := r.List(ctx, &quotas, owner); err != nil {
+		return nil, err
+	}
+	limitRanges := corev1.LimitRangeList{}
+	if err := r.List(ctx, &limitRanges, owner); err != nil {
+		return nil, err
+	}
+	owned := make([]client.Object, 0, len(quotas.Items)+len(limitRanges.Items))
+	for i := range quotas.Items {
+		owned = append(owned, &quotas.Items[i])
+	}
+	for i := range limitRanges.Items {
+		owned = append(owned, &limitRanges.Items[i])
+	}
+
+	// Quotas created before the owner label existed only carry the controller reference
+	unlabelled, err := labels.NewRequirement(constants.OwnerLabel, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, err
+	}
+	legacyQuotas := corev1.ResourceQuotaList{}
+	if err := r.List(ctx, &legacyQuotas, client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*unlabelled)}); err != nil {
+		return nil, err
+	}
+	for i := range legacyQuotas.Items {
+		if metav1.IsControlledBy(&legacyQuotas.Items[i], rl) {
+			owned = append(owned, &legacyQuotas.Items[i])
+		}
+	}
+
+	var orphans []string
+	for _, obj := range owned {
+		if !current[obj.GetNamespace()] {
+			current[obj.GetNamespace()] = true
+			orphans = append(orphans, obj.GetNamespace())
+		}
+	}
+	return orphans, nil
+}
+
+// staleNamespaces returns the namespaces the ResourceLimiter has to release, either deselected or holding an orphaned quota
+func (r *ResourceLimiterReconciler) staleNamespaces(ctx context.Context, rl *rlv1beta2.ResourceLimiter, targets []quotaTarget) ([]string, error) {
+	stale := deselectedNamespaces(rl, targets)
+	orphans, err := r.orphanedNamespaces(ctx, rl, targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range orphans {
+		found := false
+		for _, deselected := range stale {
+			if deselected == ns {
+				found = true
+				break
+			}
+		}
+		if !found {
+			stale = append(stale, ns)
+		}
+	}
+	return stale, nil
+}
+
+// releaseNamespace deletes the resource quota the ResourceLimiter owns in the namespace and removes the checker labels from it.
+// The labels are kept when the quota belongs to another ResourceLimiter or another applied ResourceLimiter still targets the namespace.
+func (r *ResourceLimiterReconciler) releaseNamespace(ctx context.Context, rl *rlv1beta2.ResourceLimiter, ns string) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	namespace := corev1.Namespace{}
+	if err := r.Get(ctx, k8stypes.NamespacedName{Name: ns}, &namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.WithName("ResourceLimiter").Info(fmt.Sprintf("namespace %s not found, continue deleting", ns))
+			return nil
+		}
+		return err
+	}
+
+	foreignQuota := false
+	resourceQuota := corev1.ResourceQuota{}
+	namespacedName := k8stypes.NamespacedName{Namespace: ns, Name: fmt.Sprintf("rl-quota-%s", ns)}
+	if err := r.Get(ctx, namespacedName, &resourceQuota); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+	} else if resourceQuota.Labels[constants.OwnerLabel] != rl.Name && !metav1.IsControlledBy(&resourceQuota, rl) {
+		foreignQuota = true
+	} else {
+		if err := r.Delete(ctx, &resourceQuota); err != nil && !apierrors.IsNotFound(err) {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to delete quota %s", resourceQuota.Name))
+			r.recordEvent(rl, &namespace, corev1.EventTypeWarning, constants.ReasonQuotaUpdateFailed, fmt.Sprintf("unable to delete resource quota %s: %v", resourceQuota.Name, err))
+			return err
+		}
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("resource quota %s deleted", resourceQuota.Name))
+		r.recordEvent(rl, &namespace, corev1.EventTypeNormal, constants.EventReasonQuotaDeleted, fmt.Sprintf("resource quota %s deleted as the namespace is no longer targeted", resourceQuota.Name))
+	}
+	if err := r.deleteLimitRange(ctx, rl, &namespace, "the namespace is no longer targeted"); err != nil {
+		r.recordEvent(rl, &namespace, corev1.EventTypeWarning, constants.ReasonLimitRangeUpdateFailed, fmt.Sprintf("unable to delete limit range %s: %v", limitRangeName(ns), err))
+		return err
+	}
+
+	if foreignQuota {
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("resource quota %s is not owned, keep the labels of namespace %s", resourceQuota.Name, ns))
+		return nil
+	}
+	overlaps, err := conflict.Overlaps(ctx, r.Client, rl, []string{ns})
+	if err != nil {
+		return err
+	}
+	if other, ok := overlaps[ns]; ok {
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("namespace %s is still targeted by %s, keep its labels", ns, other.Name))
+		return nil
+	}
+
+	// Remove mutate and validate labels for namespace
+	newNamespace := namespace.DeepCopy()
+	for k := range namespace.GetLabels() {
+		if k == constants.MutateNamespaceLabel || k == constants.ValidateNamespaceLabel {
+			delete(newNamespace.Labels, k)
+		}
+	}
+	log.WithName("ResourceLimiter").Info(fmt.Sprintf("remove labels for namespace %s", ns))
+	if err := r.Update(ctx, newNamespace); err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("namespace %s label failed", ns))
+		r.recordEvent(rl, &namespace, corev1.EventTypeWarning, constants.ReasonNamespaceLabelFailed, fmt.Sprintf("unable to remove the checker labels: %v", err))
+		return err
+	}
+	r.recordEvent(rl, &namespace, corev1.EventTypeNormal, constants.EventReasonLabelsRemoved, "checker labels removed, workloads are no longer mutated or validated")
+	return nil
+}
+
+// namespaceToLimiters maps a namespace event to the ResourceLimiters that target it by name
+// or may select it by labels, so that new or relabelled namespaces get (or lose) their quota.
+func (r *ResourceLimiterReconciler) namespaceToLimiters(obj client.Object) []reconcile.Request {
+	rls := rlv1beta2.ResourceLimiterList{}
+	if err := r.List(context.Background(), &rls); err != nil {
+		ctrl.Log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to list resourcelimiters for namespace %s", obj.GetName()))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, rl := range rls.Items {
+		for _, quota := range rl.Spec.Quotas {
+			if quota.NamespaceName == obj.GetName() || quota.NamespaceSelector != nil {
+				requests = append(requests, reconcile.Request{NamespacedName: k8stypes.NamespacedName{Name: rl.Name}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// outrankedNamespaces returns the targets also targeted by a higher ranked ResourceLimiter, with that ResourceLimiter
+func (r *ResourceLimiterReconciler) outrankedNamespaces(ctx context.Context, rl *rlv1beta2.ResourceLimiter, targets []quotaTarget) (map[string]*rlv1beta2.ResourceLimiter, error) {
+	namespaces := make([]string, 0, len(targets))
+	for _, target := range targets {
+		namespaces = append(namespaces, target.namespace)
+	}
+	overlaps, err := conflict.Overlaps(ctx, r.Client, rl, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	outranked := map[string]*rlv1beta2.ResourceLimiter{}
+	for ns, other := range overlaps {
+		if conflict.Outranks(other, rl) {
+			outranked[ns] = other
+		}
+	}
+	return outranked, nil
+}
+
+// limiterToLimiters maps a ResourceLimiter event to all the other ResourceLimiters,
+// so that conflicts are resolved again when one of them changes or goes away
+func (r *ResourceLimiterReconciler) limiterToLimiters(obj client.Object) []reconcile.Request {
+	rls := rlv1beta2.ResourceLimiterList{}
+	if err := r.List(context.Background(), &rls); err != nil {
+		ctrl.Log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to list resourcelimiters for resourcelimiter %s", obj.GetName()))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, rl := range rls.Items {
+		if rl.Name != obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: k8stypes.NamespacedName{Name: rl.Name}})
+		}
+	}
+	return requests
+}
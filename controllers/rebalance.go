@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+const (
+	defaultRebalanceInterval = 5 * time.Minute
+	// maxBudgetAdjustments is how many adjustments the status of a ResourceLimiter keeps
+	maxBudgetAdjustments = 20
+)
+
+func rebalanceInterval(rebalance *rlv1beta2.BudgetRebalance) time.Duration {
+	if rebalance.Interval.Duration <= 0 {
+		return defaultRebalanceInterval
+	}
+	return rebalance.Interval.Duration
+}
+
+func watermarks(rebalance *rlv1beta2.BudgetRebalance) (int64, int64) {
+	high, low := int64(rebalance.HighWatermark), int64(rebalance.LowWatermark)
+	if high <= 0 {
+		high = constants.DefaultHighWatermark
+	}
+	if low <= 0 {
+		low = constants.DefaultLowWatermark
+	}
+	return high, low
+}
+
+// rebalanceDue reports whether an interval passed since the last rebalance
+func rebalanceDue(rebalance *rlv1beta2.BudgetRebalance, last *metav1.Time, now metav1.Time) bool {
+	return last == nil || now.Sub(last.Time) >= rebalanceInterval(rebalance)
+}
+
+// nextRebalance returns how long until the next rebalance
+func nextRebalance(rebalance *rlv1beta2.BudgetRebalance, last *metav1.Time, now metav1.Time) time.Duration {
+	if last == nil {
+		return rebalanceInterval(rebalance)
+	}
+	if delay := rebalanceInterval(rebalance) - now.Sub(last.Time); delay > 0 {
+		return delay
+	}
+	return time.Second
+}
+
+// budgetNamespace is the state of one namespace while a resource is rebalanced
+type budgetNamespace struct {
+	name  string
+	share *big.Int
+	used  *big.Int
+	// move is what the namespace can give, or wants to get, in this rebalance
+	move *big.Int
+}
+
+// busier reports whether a uses a larger part of its share than b
+func busier(a, b budgetNamespace) bool {
+	if a.share.Sign() == 0 || b.share.Sign() == 0 {
+		return b.share.Sign() != 0
+	}
+	return new(big.Int).Mul(a.used, b.share).Cmp(new(big.Int).Mul(b.used, a.share)) > 0
+}
+
+// rebalanceShares moves the headroom of the idle namespaces to the ones above the high watermark, resource by resource.
+// Both aim at the low watermark: a busy namespace gets what brings its usage down to it, an idle one keeps
+// what keeps its usage at it. Shares stay within the floor and the ceiling, change by at most the max step
+// and keep adding up to the same total. Namespaces whose quota reports no usage yet are left alone.
+func rebalanceShares(rebalance *rlv1beta2.BudgetRebalance, budget corev1.ResourceList, namespaces []string, quotas []*corev1.ResourceQuota, shares map[string]corev1.ResourceList, now metav1.Time) []rlv1beta2.ResourceLimiterBudgetAdjustment {
+	high, low := watermarks(rebalance)
+	var adjustments []rlv1beta2.ResourceLimiterBudgetAdjustment
+	for _, name := range sortedResourceNames(budget) {
+		scale := budgetScale(name)
+		total := budget[name]
+		limit := func(list corev1.ResourceList) *big.Int {
+			if value, ok := list[name]; ok {
+				return budgetUnits(value, scale)
+			}
+			return nil
+		}
+		floor, ceiling, step := limit(rebalance.Floor), limit(rebalance.Ceiling), limit(rebalance.MaxStep)
+
+		var donors, receivers []budgetNamespace
+		given, wanted := new(big.Int), new(big.Int)
+		for i, ns := range namespaces {
+			if quotas[i] == nil || quotas[i].Status.Used == nil {
+				continue
+			}
+			current := budgetNamespace{
+				name:  ns,
+				share: budgetUnits(shares[ns][name], scale),
+				used:  budgetUnits(quotas[i].Status.Used[name], scale),
+			}
+			// target is the share at which the usage is at the low watermark, rounded up
+			target := new(big.Int).Mul(current.used, big.NewInt(100))
+			target.Add(target, big.NewInt(low-1)).Quo(target, big.NewInt(low))
+
+			usage := new(big.Int).Mul(current.used, big.NewInt(100))
+			switch {
+			case usage.Cmp(new(big.Int).Mul(current.share, big.NewInt(high))) >= 0:
+				current.move = new(big.Int).Sub(target, current.share)
+				if ceiling != nil && new(big.Int).Add(current.share, current.move).Cmp(ceiling) > 0 {
+					current.move.Sub(ceiling, current.share)
+				}
+				if step != nil && current.move.Cmp(step) > 0 {
+					current.move.Set(step)
+				}
+				if current.move.Sign() > 0 {
+					receivers = append(receivers, current)
+					wanted.Add(wanted, current.move)
+				}
+			case current.share.Cmp(target) > 0:
+				keep := target
+				if floor != nil && floor.Cmp(keep) > 0 {
+					keep = floor
+				}
+				current.move = new(big.Int).Sub(current.share, keep)
+				if step != nil && current.move.Cmp(step) > 0 {
+					current.move.Set(step)
+				}
+				if current.move.Sign() > 0 {
+					donors = append(donors, current)
+					given.Add(given, current.move)
+				}
+			}
+		}
+		pool := given
+		if wanted.Cmp(pool) < 0 {
+			pool = wanted
+		}
+		if pool.Sign() == 0 {
+			continue
+		}
+
+		// The busiest namespaces are served first, and the idlest give first
+		sort.SliceStable(receivers, func(i, j int) bool { return busier(receivers[i], receivers[j]) })
+		sort.SliceStable(donors, func(i, j int) bool { return busier(donors[j], donors[i]) })
+		move := func(candidates []budgetNamespace, sign int64) {
+			left := new(big.Int).Set(pool)
+			for _, candidate := range candidates {
+				if left.Sign() == 0 {
+					break
+				}
+				amount := candidate.move
+				if amount.Cmp(left) > 0 {
+					amount = new(big.Int).Set(left)
+				}
+				left.Sub(left, amount)
+				updated := new(big.Int).Add(candidate.share, new(big.Int).Mul(amount, big.NewInt(sign)))
+				adjustments = append(adjustments, rlv1beta2.ResourceLimiterBudgetAdjustment{
+					Time:      now,
+					Namespace: candidate.name,
+					Resource:  name,
+					From:      budgetQuantity(candidate.share, scale, total.Format),
+					To:        budgetQuantity(updated, scale, total.Format),
+					Used:      budgetQuantity(candidate.used, scale, total.Format),
+				})
+				shares[candidate.name][name] = budgetQuantity(updated, scale, total.Format)
+			}
+		}
+		move(donors, -1)
+		move(receivers, 1)
+	}
+	return adjustments
+}
+
+func sortedResourceNames(list corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
@@ -19,11 +19,14 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"math"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 
 	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -35,7 +38,10 @@ import (
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
@@ -45,11 +51,20 @@ var (
 	concurrentWorkers int = 1
 )
 
+const (
+	namespaceRetryBaseDelay = 1 * time.Second
+	namespaceRetryMaxDelay  = 5 * time.Minute
+)
+
 // ResourceLimiterReconciler reconciles a ResourceLimiter object
 type ResourceLimiterReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// backoff delays the retries of every failed namespace on its own
+	backoff workqueue.RateLimiter
 }
 
 // Event filter
@@ -64,11 +79,18 @@ func eventPredicate() predicate.Predicate {
 //+kubebuilder:rbac:groups=resources.resourcelimiter.io,resources=resourcelimiters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=resources.resourcelimiter.io,resources=resourcelimiters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=resourcequotas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=limitranges,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 //+kubebuilder:rbac:groups=resources.resourcelimiter.io,resources=resourcelimiters/finalizers,verbs=update;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *ResourceLimiterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
+	start := time.Now()
+	defer func() {
+		reconcileDuration.WithLabelValues(req.Name).Observe(time.Since(start).Seconds())
+	}()
 
 	rl := rlv1beta2.ResourceLimiter{}
 	if err := r.Get(ctx, req.NamespacedName, &rl); err != nil {
@@ -101,6 +123,9 @@ func (r *ResourceLimiterReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ResourceLimiterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.backoff == nil {
+		r.backoff = workqueue.NewItemExponentialFailureRateLimiter(namespaceRetryBaseDelay, namespaceRetryMaxDelay)
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		WithOptions(ctrlcontroller.Options{MaxConcurrentReconciles: concurrentWorkers}).
 		For(&rlv1beta2.ResourceLimiter{}).
@@ -110,6 +135,23 @@ func (r *ResourceLimiterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				IsController: true,
 				OwnerType:    &rlv1beta2.ResourceLimiter{},
 			}).
+		Watches(
+			&source.Kind{Type: &corev1.LimitRange{}},
+			&handler.EnqueueRequestForOwner{
+				IsController: true,
+				OwnerType:    &rlv1beta2.ResourceLimiter{},
+			}).
+		Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			handler.EnqueueRequestsFromMapFunc(r.namespaceToLimiters)).
+		Watches(
+			&source.Kind{Type: &corev1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(r.nodeToLimiters),
+			builder.WithPredicates(nodePredicate())).
+		Watches(
+			&source.Kind{Type: &rlv1beta2.ResourceLimiter{}},
+			handler.EnqueueRequestsFromMapFunc(r.limiterToLimiters),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		WithEventFilter(eventPredicate()).
 		Complete(r)
 }
@@ -121,58 +163,23 @@ func (r *ResourceLimiterReconciler) reconcileDelete(ctx context.Context, rl *rlv
 	}
 
 	log.WithName("ResourceLimiter").Info(fmt.Sprintf("start delete related resources according to %s resourcelimiter CR", rl.Name))
-	var (
-		resourceQuota corev1.ResourceQuota
-		namespace     corev1.Namespace
-		// reused
-		namespacedName k8stypes.NamespacedName
-	)
-
 	if rl.Status.State != constants.Stopped {
-		for _, quota := range rl.Spec.Quotas {
-			// Check if namespace exists
-			namespacedName = k8stypes.NamespacedName{Namespace: "", Name: quota.NamespaceName}
-			if err := r.Get(ctx, namespacedName, &namespace); err != nil {
-				if apierrors.IsNotFound(err) {
-					log.WithName("ResourceLimiter").Info(fmt.Sprintf("namespace %s not found, continue deleting", quota.NamespaceName))
-					continue
-				}
-				return ctrl.Result{}, err
-			}
-
-			namespacedName = k8stypes.NamespacedName{Namespace: quota.NamespaceName, Name: fmt.Sprintf("rl-quota-%s", quota.NamespaceName)}
-			resourceQuota = corev1.ResourceQuota{}
-			if err := r.Get(ctx, namespacedName, &resourceQuota); err != nil {
-				return ctrl.Result{}, err
-			}
-
-			if err := r.Delete(ctx, &resourceQuota); err != nil {
-				log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to delete quota %s", fmt.Sprintf("rl-quota-%s", quota.NamespaceName)))
-				return ctrl.Result{}, err
-			}
-			log.WithName("ResourceLimiter").Info(fmt.Sprintf("resource quota %s deleted", fmt.Sprintf("rl-quota-%s", quota.NamespaceName)))
-
-			// Remove mutate and validate labels for namespace
-			namespacedName = k8stypes.NamespacedName{Namespace: quota.NamespaceName, Name: quota.NamespaceName}
-			if err := r.Get(ctx, namespacedName, &namespace); err != nil {
-				if apierrors.IsNotFound(err) {
-					log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("remove: namespace %s for resource quota not found, please create it first", quota.NamespaceName))
-				} else {
-					log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("remove: get namespace %s for resource quota failed", quota.NamespaceName))
-				}
-				return ctrl.Result{}, err
-			}
-			newNamespace := namespace.DeepCopy()
-			for k := range namespace.GetLabels() {
-				if k == constants.MutateNamespaceLabel || k == constants.ValidateNamespaceLabel {
-					delete(newNamespace.Labels, k)
-				}
-			}
-			log.WithName("ResourceLimiter").Info(fmt.Sprintf("remove labels for namespace %s", quota.NamespaceName))
-			if err := r.Update(ctx, newNamespace); err != nil {
-				log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("namespace %s label failed", quota.NamespaceName))
+		targets, _, err := r.resolveTargets(ctx, rl)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		namespaces, err := r.staleNamespaces(ctx, rl, targets)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		for _, target := range targets {
+			namespaces = append(namespaces, target.namespace)
+		}
+		for _, ns := range namespaces {
+			if err := r.releaseNamespace(ctx, rl, ns); err != nil {
 				return ctrl.Result{}, err
 			}
+			r.backoff.Forget(backoffKey(rl, ns))
 		}
 	}
 
@@ -183,154 +190,434 @@ func (r *ResourceLimiterReconciler) reconcileDelete(ctx context.Context, rl *rlv
 		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to register finalizer FOR %s", newrl.Name))
 		return ctrl.Result{}, err
 	}
+	r.recordEvent(rl, nil, corev1.EventTypeNormal, constants.EventReasonFinalizerRemoved, "quotas released, finalizer removed")
+	forgetMetrics(rl)
 
 	return ctrl.Result{}, nil
 }
 
-func setHard(resourceQuota *corev1.ResourceQuota, quota rlv1beta2.ResourceLimiterQuota) {
-	resourceQuota.Spec.Hard[corev1.ResourceLimitsCPU] = k8sresource.MustParse(quota.CpuLimit)
-	resourceQuota.Spec.Hard[corev1.ResourceRequestsCPU] = k8sresource.MustParse(quota.CpuRequest)
-	resourceQuota.Spec.Hard[corev1.ResourceLimitsMemory] = k8sresource.MustParse(quota.MemLimit)
-	resourceQuota.Spec.Hard[corev1.ResourceRequestsMemory] = k8sresource.MustParse(quota.MemRequest)
+// setHard fills the hard limits of the resource quota, a malformed quantity is returned as an error
+func setHard(resourceQuota *corev1.ResourceQuota, quota rlv1beta2.ResourceLimiterQuota) error {
+	shorthands := []struct {
+		name  corev1.ResourceName
+		field string
+		value string
+	}{
+		{corev1.ResourceLimitsCPU, "cpu_limits", quota.CpuLimit},
+		{corev1.ResourceRequestsCPU, "cpu_requests", quota.CpuRequest},
+		{corev1.ResourceLimitsMemory, "mem_limits", quota.MemLimit},
+		{corev1.ResourceRequestsMemory, "mem_requests", quota.MemRequest},
+	}
+	for _, shorthand := range shorthands {
+		if shorthand.value == "" {
+			continue
+		}
+		value, err := k8sresource.ParseQuantity(shorthand.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q of namespace %s: %v", shorthand.field, shorthand.value, quota.NamespaceName, err)
+		}
+		resourceQuota.Spec.Hard[shorthand.name] = value
+	}
+	// Generic resources override the shorthands
+	for name, value := range quota.Hard {
+		resourceQuota.Spec.Hard[name] = value.DeepCopy()
+	}
+	return nil
+}
+
+// namespaceStatus reports the hard, used and remaining amounts of every resource limited by the resource quota
+func namespaceStatus(resourceQuota *corev1.ResourceQuota) rlv1beta2.ResourceLimiterNamespaceStatus {
+	status := rlv1beta2.ResourceLimiterNamespaceStatus{
+		Namespace:          resourceQuota.Namespace,
+		ResourceQuota:      corev1.LocalObjectReference{Name: resourceQuota.Name},
+		Hard:               resourceQuota.Spec.Hard.DeepCopy(),
+		Used:               corev1.ResourceList{},
+		Remaining:          corev1.ResourceList{},
+		UtilizationPercent: map[corev1.ResourceName]int64{},
+	}
+	for name, hard := range resourceQuota.Spec.Hard {
+		used := resourceQuota.Status.Used[name].DeepCopy()
+		remaining := hard.DeepCopy()
+		remaining.Sub(used)
+		status.Used[name] = used
+		status.Remaining[name] = remaining
+		status.UtilizationPercent[name] = utilizationPercent(used, hard)
+	}
+	return status
+}
+
+// quotaStatus reports the usage of a resource quota as "used/hard" for the shorthand fields,
+// and the hard limits of the target unchanged, for the deprecated status.quotas
+func quotaStatus(usage rlv1beta2.ResourceLimiterNamespaceStatus, quota rlv1beta2.ResourceLimiterQuota) rlv1beta2.ResourceLimiterQuota {
+	shorthand := func(name corev1.ResourceName, hard string) string {
+		if hard == "" {
+			return ""
+		}
+		used := usage.Used[name]
+		return fmt.Sprintf("%s/%s", used.String(), hard)
+	}
+	return rlv1beta2.ResourceLimiterQuota{
+		NamespaceName: usage.ResourceQuota.Name,
+		CpuLimit:      shorthand(corev1.ResourceLimitsCPU, quota.CpuLimit),
+		CpuRequest:    shorthand(corev1.ResourceRequestsCPU, quota.CpuRequest),
+		MemLimit:      shorthand(corev1.ResourceLimitsMemory, quota.MemLimit),
+		MemRequest:    shorthand(corev1.ResourceRequestsMemory, quota.MemRequest),
+		Hard:          quota.Hard.DeepCopy(),
+	}
+}
+
+func utilizationPercent(used, hard k8sresource.Quantity) int64 {
+	if hard.IsZero() {
+		if used.IsZero() {
+			return 0
+		}
+		return 100
+	}
+	return int64(math.Round(used.AsApproximateFloat64() / hard.AsApproximateFloat64() * 100))
 }
 
 func (r *ResourceLimiterReconciler) reconcile(ctx context.Context, rl *rlv1beta2.ResourceLimiter) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
 
-	// Create ResourceQuota per namespace
 	var (
-		namespace                                                  corev1.Namespace
-		namespacedName                                             k8stypes.NamespacedName
-		resourceQuota                                              = &corev1.ResourceQuota{}
-		rlquotas                                                   = []rlv1beta2.ResourceLimiterQuota{}
-		curCpuLimits, curCpuRequests, curMemLimits, curMemRequests k8sresource.Quantity
-		// nextCpuLimits, nextCpuRequests, nextMemLimits, nextMemRequests k8sresource.Quantity
+		usages   = []rlv1beta2.ResourceLimiterNamespaceStatus{}
+		quotas   = []rlv1beta2.ResourceLimiterQuota{}
+		failures []namespaceFailure
 	)
 
-	for _, quota := range rl.Spec.Quotas {
-		if quota.NamespaceName == string(constants.IgnoreKubeSystem) || quota.NamespaceName == string(constants.IgnoreKubePublic) {
+	targets, selected, err := r.resolveTargets(ctx, rl)
+	if err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("resolve target namespaces of %s failed", rl.Name))
+		return r.fail(ctx, rl, rl.Status.Selected, namespaceFailure{reason: constants.ReasonInvalidQuota, err: err})
+	}
+
+	// Namespaces which are no longer targeted lose their quota
+	stale, err := r.staleNamespaces(ctx, rl, targets)
+	if err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("list resource quotas of %s failed", rl.Name))
+		return r.fail(ctx, rl, selected, namespaceFailure{reason: constants.ReasonReconcileFailed, err: err})
+	}
+	for _, ns := range stale {
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("namespace %s is no longer targeted by %s", ns, rl.Name))
+		if err := r.releaseNamespace(ctx, rl, ns); err != nil {
+			failures = append(failures, namespaceFailure{namespace: ns, reason: constants.ReasonQuotaUpdateFailed, err: err})
 			continue
 		}
-		// Make sure namespace exists and label it with checker label
-		namespacedName = k8stypes.NamespacedName{Namespace: quota.NamespaceName, Name: quota.NamespaceName}
-		if err := r.Get(ctx, namespacedName, &namespace); err != nil {
-			if apierrors.IsNotFound(err) {
-				log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("namespace %s for resource quota not found, please create it first", string(quota.NamespaceName)))
-			} else {
-				log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("get namespace %s for resource quota failed", quota.NamespaceName))
-			}
-			return ctrl.Result{}, err
+		r.backoff.Forget(backoffKey(rl, ns))
+	}
+
+	// Namespaces also targeted by a higher ranked ResourceLimiter are left to it
+	outranked, err := r.outrankedNamespaces(ctx, rl, targets)
+	if err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("detect conflicts of %s failed", rl.Name))
+		return r.fail(ctx, rl, selected, namespaceFailure{reason: constants.ReasonReconcileFailed, err: err})
+	}
+
+	// Percentages of the allocatable capacity are resolved again on every reconcile, as nodes come and go
+	var capacity *rlv1beta2.ResourceLimiterCapacityStatus
+	if rl.Spec.Applied {
+		if capacity, err = r.resolveCapacity(ctx, rl, targets); err != nil {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("resolve the percentages of %s failed", rl.Name))
+			return r.fail(ctx, rl, selected, namespaceFailure{reason: constants.ReasonInvalidQuota, err: err})
 		}
+	}
 
-		// Set mutate and validate label for namespace if not presesnt
-		newNamespace := namespace.DeepCopy()
-		if len(newNamespace.Labels) == 0 {
-			newNamespace.Labels = map[string]string{}
+	var plan *budgetPlan
+	if rl.Spec.Budget != nil && rl.Spec.Applied {
+		if plan, err = r.planBudget(ctx, rl, targets, outranked, metav1.Now()); err != nil {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("divide the budget of %s failed", rl.Name))
+			return r.fail(ctx, rl, selected, namespaceFailure{reason: constants.ReasonInvalidQuota, err: err})
 		}
-		if val, ok := newNamespace.Labels[constants.MutateNamespaceLabel]; !ok || val != "enabled" {
-			newNamespace.Labels[constants.MutateNamespaceLabel] = "enabled"
+		if plan.divided && sharesChanged(rl.Status.Budget, plan.status) {
+			r.recordEvent(rl, nil, corev1.EventTypeNormal, constants.EventReasonBudgetDivided,
+				fmt.Sprintf("budget %s divided across %d namespace(s): %s", formatHard(rl.Spec.Budget.Hard), len(plan.status.Shares), formatShares(plan.status.Shares)))
 		}
-		if val, ok := newNamespace.Labels[constants.ValidateNamespaceLabel]; !ok || val != "enabled" {
-			newNamespace.Labels[constants.ValidateNamespaceLabel] = "enabled"
+		for _, adjustment := range plan.adjustments {
+			r.recordEvent(rl, plan.namespaces[adjustment.Namespace], corev1.EventTypeNormal, constants.EventReasonBudgetRebalanced,
+				fmt.Sprintf("%s share of namespace %s rebalanced from %s to %s, %s used", adjustment.Resource, adjustment.Namespace, adjustment.From.String(), adjustment.To.String(), adjustment.Used.String()))
 		}
+		// Shrinking first keeps the sum of the shares within the budget at any time
+		targets = plan.order(targets)
+	}
 
-		log.WithName("ResourceLimiter").Info(fmt.Sprintf("set labels for namespace %s", quota.NamespaceName))
-		if err := r.Update(ctx, newNamespace); err != nil {
-			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("namespace %s label failed", quota.NamespaceName))
-			return ctrl.Result{}, err
+	// A failing namespace must not block the others, it is retried on its own backoff
+	shrinkFailed := false
+	for _, target := range targets {
+		if winner, ok := outranked[target.namespace]; ok {
+			log.WithName("ResourceLimiter").Info(fmt.Sprintf("namespace %s of %s is left to resourcelimiter %s", target.namespace, rl.Name, winner.Name))
+			failures = append(failures, namespaceFailure{
+				namespace: target.namespace,
+				reason:    constants.ReasonOutranked,
+				err:       fmt.Errorf("also targeted by resourcelimiter %s which outranks %s", winner.Name, rl.Name),
+			})
+			continue
 		}
-
-		// Generate target resource quota spec
-		resourceQuota = &corev1.ResourceQuota{}
-		if rl.Spec.Applied {
-			// nextCpuLimits = k8sresource.MustParse(quota.CpuLimit)
-			// nextCpuRequests = k8sresource.MustParse(quota.CpuRequest)
-			// nextMemLimits = k8sresource.MustParse(quota.MemLimit)
-			// nextMemRequests = k8sresource.MustParse(quota.MemRequest)
-			namespacedName = k8stypes.NamespacedName{Namespace: quota.NamespaceName, Name: fmt.Sprintf("rl-quota-%s", quota.NamespaceName)}
-			log.WithName("ResourceLimiter").Info(fmt.Sprintf("create or update the resource quota %s", fmt.Sprintf("rl-quota-%s", quota.NamespaceName)))
-			if err := r.Get(ctx, namespacedName, resourceQuota); err != nil {
-				if apierrors.IsNotFound(err) {
-					log.WithName("ResourceLimiter").Info(fmt.Sprintf("create resource quota %s", fmt.Sprintf("rl-quota-%s", quota.NamespaceName)))
-					resourceQuota.Name = fmt.Sprintf("rl-quota-%s", quota.NamespaceName)
-					resourceQuota.Namespace = quota.NamespaceName
-					if err := controllerutil.SetControllerReference(rl, resourceQuota, r.Scheme); err != nil {
-						log.WithName("ResourceLimiter").Error(err, "Set ResourceLimiter as the owner and controller")
-						return ctrl.Result{}, err
-					}
-					resourceQuota.Spec.Hard = map[corev1.ResourceName]k8sresource.Quantity{}
-					setHard(resourceQuota, quota)
-
-					rlquotas = append(rlquotas, rlv1beta2.ResourceLimiterQuota{
-						NamespaceName: fmt.Sprintf("rl-quota-%s", quota.NamespaceName),
-						CpuLimit:      fmt.Sprintf("0/%s", quota.CpuLimit),
-						CpuRequest:    fmt.Sprintf("0/%s", quota.CpuRequest),
-						MemLimit:      fmt.Sprintf("0/%s", quota.MemLimit),
-						MemRequest:    fmt.Sprintf("0/%s", quota.MemRequest),
-					})
-					if er := r.Create(ctx, resourceQuota); er != nil {
-						log.WithName("ResourceLimiter").Error(er, fmt.Sprintf("create the quopta %s failed", resourceQuota.Name))
-						return ctrl.Result{}, er
-					}
-					log.WithName("ResourceLimiter").Info(fmt.Sprintf("create resource quota %s successfully", resourceQuota.Name))
-					//if err := r.updateStatus(ctx, rl, rlv1beta1.ResourceLimiterStatus{State: constants.Ready, Quotas: rlquotas}); err != nil {
-					//	return ctrl.Result{}, err
-					//}
-					return ctrl.Result{}, nil
-				}
-				log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("get the quota %s failed", fmt.Sprintf("rl-quota-%s", quota.NamespaceName)))
-				return ctrl.Result{}, err
-			} else {
-				currl := resourceQuota.DeepCopy()
-				curCpuLimits = currl.Status.Used[corev1.ResourceName(constants.RetrainTypeLimitsCpu)]
-				curCpuRequests = currl.Status.Used[corev1.ResourceName(constants.RetrainTypeRequestsCpu)]
-				curMemLimits = currl.Status.Used[corev1.ResourceName(constants.RetrainTypeLimitsMemory)]
-				curMemRequests = currl.Status.Used[corev1.ResourceName(constants.RetrainTypeRequestsMemory)]
-				resourceQuota.Spec.Hard = map[corev1.ResourceName]k8sresource.Quantity{}
-				setHard(resourceQuota, quota)
-				rlquotas = append(rlquotas, rlv1beta2.ResourceLimiterQuota{
-					NamespaceName: resourceQuota.Name,
-					CpuLimit:      fmt.Sprintf("%s/%s", curCpuLimits.String(), quota.CpuLimit),
-					CpuRequest:    fmt.Sprintf("%s/%s", curCpuRequests.String(), quota.CpuRequest),
-					MemLimit:      fmt.Sprintf("%s/%s", curMemLimits.String(), quota.MemLimit),
-					MemRequest:    fmt.Sprintf("%s/%s", curMemRequests.String(), quota.MemRequest),
+		quota := target.quota
+		quota.NamespaceName = target.namespace
+		if plan != nil {
+			if plan.growing[target.namespace] && shrinkFailed {
+				failures = append(failures, namespaceFailure{
+					namespace: target.namespace,
+					reason:    constants.ReasonBudgetPending,
+					err:       fmt.Errorf("waiting for the other namespaces to give back their share of the budget"),
 				})
-				if er := r.Update(ctx, resourceQuota); er != nil {
-					return ctrl.Result{}, er
+				continue
+			}
+			quota.Hard = plan.hard(target.namespace, quota.Hard)
+		}
+		usage, failure := r.reconcileNamespace(ctx, rl, quota)
+		if failure != nil {
+			if plan != nil && !plan.growing[target.namespace] {
+				if _, shared := plan.shares[target.namespace]; shared {
+					shrinkFailed = true
 				}
+			}
+			failures = append(failures, *failure)
+			continue
+		}
+		r.backoff.Forget(backoffKey(rl, target.namespace))
+		if usage != nil {
+			usages = append(usages, *usage)
+			quotas = append(quotas, quotaStatus(*usage, quota))
+		}
+	}
+
+	state := constants.Stopped
+	if rl.Spec.Applied {
+		state = constants.Ready
+	}
+	status := rlv1beta2.ResourceLimiterStatus{State: state, Quotas: quotas, Namespaces: usages, Selected: selected, Capacity: capacity}
+	if plan != nil {
+		status.Budget = plan.status
+	}
+	if err := r.updateStatus(ctx, rl, status, failures); err != nil {
+		reconcileTotal.WithLabelValues(rl.Name, reconcileError).Inc()
+		return ctrl.Result{}, err
+	}
+	recordMetrics(rl, usages, failures)
+	result := r.requeueFailures(rl, failures)
+	if plan != nil && rl.Spec.Budget.Rebalance != nil {
+		if delay := nextRebalance(rl.Spec.Budget.Rebalance, plan.status.LastRebalanceTime, metav1.Now()); result.RequeueAfter == 0 || delay < result.RequeueAfter {
+			result.RequeueAfter = delay
+		}
+	}
+	return result, nil
+}
 
-				log.WithName("ResourceLimiter").Info(fmt.Sprintf("update resource quota %s successfully", resourceQuota.Name))
+// reconcileNamespace labels the namespace and creates, updates or deletes its resource quota,
+// it returns the usage of the quota when the ResourceLimiter is applied
+func (r *ResourceLimiterReconciler) reconcileNamespace(ctx context.Context, rl *rlv1beta2.ResourceLimiter, quota rlv1beta2.ResourceLimiterQuota) (*rlv1beta2.ResourceLimiterNamespaceStatus, *namespaceFailure) {
+	log := ctrl.LoggerFrom(ctx)
+	namespace := corev1.Namespace{}
+	failed := func(reason string, err error) (*rlv1beta2.ResourceLimiterNamespaceStatus, *namespaceFailure) {
+		failure := namespaceFailure{namespace: quota.NamespaceName, reason: reason, err: err}
+		r.recordEvent(rl, &namespace, corev1.EventTypeWarning, reason, failure.message())
+		return nil, &failure
+	}
+
+	// Make sure namespace exists and label it with checker label
+	if err := r.Get(ctx, k8stypes.NamespacedName{Name: quota.NamespaceName}, &namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("namespace %s for resource quota not found, please create it first", quota.NamespaceName))
+			return failed(constants.ReasonNamespaceNotFound, err)
+		}
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("get namespace %s for resource quota failed", quota.NamespaceName))
+		return failed(constants.ReasonReconcileFailed, err)
+	}
+
+	// Set mutate and validate label for namespace if not presesnt
+	newNamespace := namespace.DeepCopy()
+	if len(newNamespace.Labels) == 0 {
+		newNamespace.Labels = map[string]string{}
+	}
+	if val, ok := newNamespace.Labels[constants.MutateNamespaceLabel]; !ok || val != "enabled" {
+		newNamespace.Labels[constants.MutateNamespaceLabel] = "enabled"
+	}
+	if val, ok := newNamespace.Labels[constants.ValidateNamespaceLabel]; !ok || val != "enabled" {
+		newNamespace.Labels[constants.ValidateNamespaceLabel] = "enabled"
+	}
+
+	log.WithName("ResourceLimiter").Info(fmt.Sprintf("set labels for namespace %s", quota.NamespaceName))
+	if err := r.Update(ctx, newNamespace); err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("namespace %s label failed", quota.NamespaceName))
+		return failed(constants.ReasonNamespaceLabelFailed, err)
+	}
+
+	resourceQuota := &corev1.ResourceQuota{}
+	quotaName := fmt.Sprintf("rl-quota-%s", quota.NamespaceName)
+	namespacedName := k8stypes.NamespacedName{Namespace: quota.NamespaceName, Name: quotaName}
+	if !rl.Spec.Applied {
+		// "No" means there is no quotas anymore, but the rl should be lefted
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("delete related resources according to %s resourcelimiter CR", rl.Name))
+		if err := r.deleteLimitRange(ctx, rl, &namespace, "the resourcelimiter is not applied"); err != nil {
+			return failed(constants.ReasonLimitRangeUpdateFailed, err)
+		}
+		if err := r.Get(ctx, namespacedName, resourceQuota); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
 			}
-		} else {
-			// "No" means there is no quotas anymore, but the rl should be lefted
-			log.WithName("ResourceLimiter").Info(fmt.Sprintf("delete related resources according to %s resourcelimiter CR", rl.Name))
-			namespacedName = k8stypes.NamespacedName{Namespace: quota.NamespaceName, Name: fmt.Sprintf("rl-quota-%s", quota.NamespaceName)}
-			if err := r.Get(ctx, namespacedName, resourceQuota); err != nil {
-				if apierrors.IsNotFound(err) {
-					continue
-				}
-				return ctrl.Result{}, err
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+		if resourceQuota.Labels[constants.OwnerLabel] != rl.Name && !metav1.IsControlledBy(resourceQuota, rl) {
+			// The quota belongs to another ResourceLimiter
+			return nil, nil
+		}
+		if err := r.Delete(ctx, resourceQuota); err != nil && !apierrors.IsNotFound(err) {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to delete quota %s", resourceQuota.Name))
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+		r.recordEvent(rl, &namespace, corev1.EventTypeNormal, constants.EventReasonQuotaDeleted, fmt.Sprintf("resource quota %s deleted as the resourcelimiter is not applied", resourceQuota.Name))
+		return nil, nil
+	}
+
+	limitRange, err := r.reconcileLimitRange(ctx, rl, &namespace, quota)
+	if err != nil {
+		return failed(constants.ReasonLimitRangeUpdateFailed, err)
+	}
+
+	// Generate target resource quota spec
+	log.WithName("ResourceLimiter").Info(fmt.Sprintf("create or update the resource quota %s", quotaName))
+	if err := r.Get(ctx, namespacedName, resourceQuota); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("get the quota %s failed", quotaName))
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("create resource quota %s", quotaName))
+		resourceQuota.Name = quotaName
+		resourceQuota.Namespace = quota.NamespaceName
+		resourceQuota.Labels = map[string]string{constants.OwnerLabel: rl.Name}
+		if err := controllerutil.SetControllerReference(rl, resourceQuota, r.Scheme); err != nil {
+			log.WithName("ResourceLimiter").Error(err, "Set ResourceLimiter as the owner and controller")
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+		resourceQuota.Spec.Hard = map[corev1.ResourceName]k8sresource.Quantity{}
+		if err := setHard(resourceQuota, quota); err != nil {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("generate the quota %s failed", resourceQuota.Name))
+			return failed(constants.ReasonInvalidQuota, err)
+		}
+		if err := setLastAppliedHard(resourceQuota, resourceQuota.Spec.Hard); err != nil {
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+		if err := r.Create(ctx, resourceQuota); err != nil {
+			log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("create the quopta %s failed", resourceQuota.Name))
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("create resource quota %s successfully", resourceQuota.Name))
+		r.recordEvent(rl, &namespace, corev1.EventTypeNormal, constants.EventReasonQuotaCreated, fmt.Sprintf("resource quota %s created with hard limits %s", resourceQuota.Name, formatHard(resourceQuota.Spec.Hard)))
+		usage := namespaceStatus(resourceQuota)
+		usage.LimitRange = limitRange
+		return &usage, nil
+	}
+
+	if !metav1.IsControlledBy(resourceQuota, rl) {
+		// Take over the quota from a ResourceLimiter this one outranks
+		log.WithName("ResourceLimiter").Info(fmt.Sprintf("take over resource quota %s", resourceQuota.Name))
+		ownerReferences := []metav1.OwnerReference{}
+		for _, ref := range resourceQuota.OwnerReferences {
+			if ref.Controller == nil || !*ref.Controller {
+				ownerReferences = append(ownerReferences, ref)
 			}
+		}
+		resourceQuota.OwnerReferences = ownerReferences
+		if err := controllerutil.SetControllerReference(rl, resourceQuota, r.Scheme); err != nil {
+			log.WithName("ResourceLimiter").Error(err, "Set ResourceLimiter as the owner and controller")
+			return failed(constants.ReasonQuotaUpdateFailed, err)
+		}
+	}
+	if resourceQuota.Labels == nil {
+		resourceQuota.Labels = map[string]string{}
+	}
+	resourceQuota.Labels[constants.OwnerLabel] = rl.Name
+	desired := &corev1.ResourceQuota{Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{}}}
+	if err := setHard(desired, quota); err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("generate the quota %s failed", resourceQuota.Name))
+		return failed(constants.ReasonInvalidQuota, err)
+	}
 
-			if err := r.Delete(ctx, resourceQuota); err != nil {
-				log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to delete quota %s", resourceQuota.Name))
-				return ctrl.Result{}, err
+	// Hard limits differing from the last applied ones were edited by hand
+	policy := rl.Spec.DriftPolicy
+	if policy == "" {
+		policy = rlv1beta2.DriftPolicyEnforce
+	}
+	live, applied := resourceQuota.Spec.Hard, lastAppliedHard(resourceQuota)
+	drifted := driftedResources(live, applied)
+	if len(drifted) > 0 {
+		message := fmt.Sprintf("resource quota %s/%s drifted (%s), drift policy %s", resourceQuota.Namespace, resourceQuota.Name, formatDrift(live, desired.Spec.Hard, drifted), policy)
+		log.WithName("ResourceLimiter").Info(message)
+		r.recordEvent(rl, &namespace, corev1.EventTypeWarning, constants.EventReasonQuotaDrift, message)
+		quotaDriftTotal.WithLabelValues(rl.Name, resourceQuota.Namespace, string(policy)).Inc()
+	}
+	// The shares of a budget are enforced whatever the policy, or they could add up to more than the budget
+	kept := withoutBudget(rl, drifted)
+	hard, newApplied := resolveDrift(policy, live, applied, desired.Spec.Hard, kept)
+	resourceQuota.Spec.Hard = hard
+	if err := setLastAppliedHard(resourceQuota, newApplied); err != nil {
+		return failed(constants.ReasonQuotaUpdateFailed, err)
+	}
+	if err := r.Update(ctx, resourceQuota); err != nil {
+		log.WithName("ResourceLimiter").Error(err, fmt.Sprintf("update the quota %s failed", resourceQuota.Name))
+		return failed(constants.ReasonQuotaUpdateFailed, err)
+	}
+	log.WithName("ResourceLimiter").Info(fmt.Sprintf("update resource quota %s successfully", resourceQuota.Name))
+	if len(driftedResources(live, hard)) > 0 {
+		r.recordEvent(rl, &namespace, corev1.EventTypeNormal, constants.EventReasonQuotaUpdated, fmt.Sprintf("resource quota %s updated to hard limits %s", resourceQuota.Name, formatHard(hard)))
+	}
+	usage := namespaceStatus(resourceQuota)
+	usage.LimitRange = limitRange
+	if policy == rlv1beta2.DriftPolicyWarn {
+		for _, name := range kept {
+			if value, ok := desired.Spec.Hard[name]; ok {
+				if usage.Drift == nil {
+					usage.Drift = corev1.ResourceList{}
+				}
+				usage.Drift[name] = value.DeepCopy()
 			}
 		}
 	}
-	if rl.Spec.Applied {
-		if err := r.updateStatus(ctx, rl, rlv1beta2.ResourceLimiterStatus{State: constants.Ready, Quotas: rlquotas}); err != nil {
-			return ctrl.Result{}, err
+	return &usage, nil
+}
+
+func backoffKey(rl *rlv1beta2.ResourceLimiter, ns string) string {
+	return fmt.Sprintf("%s/%s", rl.Name, ns)
+}
+
+// requeueFailures requeues after the shortest backoff among the failed namespaces
+func (r *ResourceLimiterReconciler) requeueFailures(rl *rlv1beta2.ResourceLimiter, failures []namespaceFailure) ctrl.Result {
+	result := ctrl.Result{}
+	for _, failure := range failures {
+		if failure.isConflict() {
+			continue
 		}
-		return ctrl.Result{}, nil
+		delay := r.backoff.When(backoffKey(rl, failure.namespace))
+		if result.RequeueAfter == 0 || delay < result.RequeueAfter {
+			result.RequeueAfter = delay
+		}
+	}
+	return result
+}
+
+// fail records the failure in the status conditions, keeping the last reported quotas, and returns its error
+func (r *ResourceLimiterReconciler) fail(ctx context.Context, rl *rlv1beta2.ResourceLimiter, selected []rlv1beta2.ResourceLimiterSelection, failure namespaceFailure) (ctrl.Result, error) {
+	reconcileTotal.WithLabelValues(rl.Name, reconcileError).Inc()
+	status := rlv1beta2.ResourceLimiterStatus{State: rl.Status.State, Quotas: rl.Status.Quotas, Namespaces: rl.Status.Namespaces, Selected: selected, Budget: rl.Status.Budget, Capacity: rl.Status.Capacity}
+	if err := r.updateStatus(ctx, rl, status, []namespaceFailure{failure}); err != nil {
+		ctrl.LoggerFrom(ctx).WithName("ResourceLimiter").Error(err, fmt.Sprintf("unable to update status of %s", rl.Name))
 	}
-	return ctrl.Result{}, r.updateStatus(ctx, rl, rlv1beta2.ResourceLimiterStatus{State: constants.Stopped, Quotas: []rlv1beta2.ResourceLimiterQuota{}})
+	return ctrl.Result{}, failure.err
 }
 
-func (r *ResourceLimiterReconciler) updateStatus(ctx context.Context, rl *rlv1beta2.ResourceLimiter, status rlv1beta2.ResourceLimiterStatus) error {
+// updateStatus writes the status and the conditions derived from the failures of this reconcile
+func (r *ResourceLimiterReconciler) updateStatus(ctx context.Context, rl *rlv1beta2.ResourceLimiter, status rlv1beta2.ResourceLimiterStatus, failures []namespaceFailure) error {
 	rl.Status.State = status.State
+	rl.Status.ObservedGeneration = rl.Generation
 	// We do a full-update
 	rl.Status.Quotas = []rlv1beta2.ResourceLimiterQuota{}
 	rl.Status.Quotas = append(rl.Status.Quotas, status.Quotas...)
+	rl.Status.Namespaces = status.Namespaces
+	rl.Status.Selected = status.Selected
+	rl.Status.Budget = status.Budget
+	rl.Status.Capacity = status.Capacity
+	setConditions(&rl.Status, rl.Generation, failures)
 	return r.Status().Update(ctx, rl.DeepCopy())
 }
@@ -4,18 +4,21 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path/filepath"
-	"reflect"
 	"time"
 
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
 	"github.com/chenliu1993/resourcelimiter/pkg/constants"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -26,6 +29,28 @@ const (
 	interval = 1 * time.Second
 )
 
+// sameUsage reports whether the status shows the target used amounts, with the remaining amounts matching them
+func sameUsage(status rlv1beta2.ResourceLimiterStatus, target map[string]corev1.ResourceList) bool {
+	if len(status.Namespaces) != len(target) {
+		return false
+	}
+	for _, ns := range status.Namespaces {
+		used, ok := target[ns.Namespace]
+		if !ok || ns.ResourceQuota.Name != fmt.Sprintf("rl-quota-%s", ns.Namespace) || len(ns.Used) != len(used) {
+			return false
+		}
+		for name, value := range used {
+			expectedRemaining := ns.Hard[name].DeepCopy()
+			expectedRemaining.Sub(value)
+			actualUsed, actualRemaining := ns.Used[name], ns.Remaining[name]
+			if actualUsed.Cmp(value) != 0 || actualRemaining.Cmp(expectedRemaining) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 var _ = Describe("ResourceLimiter controller", func() {
 	pwd, err := os.Getwd()
 	Expect(err).NotTo(HaveOccurred())
@@ -531,20 +556,18 @@ var _ = Describe("ResourceLimiter controller", func() {
 			}, timeout, interval).Should(Equal(constants.Ready))
 			existingResourceQuota2 := &corev1.ResourceQuota{}
 
-			targetQuotas := []rlv1beta2.ResourceLimiterQuota{
-				{
-					NamespaceName: fmt.Sprintf("rl-quota-%s", "default"),
-					CpuRequest:    "0/0.25",
-					CpuLimit:      "0/0.5",
-					MemLimit:      "0/150Mi",
-					MemRequest:    "0/120Mi",
+			targetUsage := map[string]corev1.ResourceList{
+				"default": {
+					corev1.ResourceRequestsCPU:    k8sresource.MustParse("0"),
+					corev1.ResourceLimitsCPU:      k8sresource.MustParse("0"),
+					corev1.ResourceLimitsMemory:   k8sresource.MustParse("0"),
+					corev1.ResourceRequestsMemory: k8sresource.MustParse("0"),
 				},
-				{
-					NamespaceName: fmt.Sprintf("rl-quota-%s", "local-path-storage"),
-					CpuRequest:    "0/0.25",
-					CpuLimit:      "0/0.5",
-					MemLimit:      "0/150Mi",
-					MemRequest:    "0/120Mi",
+				"local-path-storage": {
+					corev1.ResourceRequestsCPU:    k8sresource.MustParse("0"),
+					corev1.ResourceLimitsCPU:      k8sresource.MustParse("0"),
+					corev1.ResourceLimitsMemory:   k8sresource.MustParse("0"),
+					corev1.ResourceRequestsMemory: k8sresource.MustParse("0"),
 				},
 			}
 			for _, tgt := range existingResourceLimiter2.Spec.Quotas {
@@ -558,7 +581,12 @@ var _ = Describe("ResourceLimiter controller", func() {
 				}, timeout, interval).Should(Equal(true))
 
 			}
-			Expect(reflect.DeepEqual(existingResourceLimiter2.Status.Quotas, targetQuotas)).Should(Equal(true))
+			Expect(sameUsage(existingResourceLimiter2.Status, targetUsage)).Should(Equal(true))
+			// The deprecated quotas keep reporting the shorthands as "used/hard"
+			Expect(existingResourceLimiter2.Status.Quotas).Should(Equal([]rlv1beta2.ResourceLimiterQuota{
+				{NamespaceName: "rl-quota-default", CpuRequest: "0/0.25", CpuLimit: "0/0.5", MemRequest: "0/120Mi", MemLimit: "0/150Mi"},
+				{NamespaceName: "rl-quota-local-path-storage", CpuRequest: "0/0.25", CpuLimit: "0/0.5", MemRequest: "0/120Mi", MemLimit: "0/150Mi"},
+			}))
 
 			By("By checking all the related quotas after creating the target pod")
 			Expect(k8sClient.Create(ctx, podOk)).Should(Succeed())
@@ -577,24 +605,22 @@ var _ = Describe("ResourceLimiter controller", func() {
 				return existingResourceLimiter3.Status.State
 			}, timeout, interval).Should(Equal(constants.Ready))
 
-			targetQuotas = []rlv1beta2.ResourceLimiterQuota{
-				{
-					NamespaceName: fmt.Sprintf("rl-quota-%s", "default"),
-					CpuRequest:    "100m/0.25",
-					CpuLimit:      "200m/0.5",
-					MemLimit:      "100Mi/150Mi",
-					MemRequest:    "90Mi/120Mi",
+			targetUsage = map[string]corev1.ResourceList{
+				"default": {
+					corev1.ResourceRequestsCPU:    k8sresource.MustParse("100m"),
+					corev1.ResourceLimitsCPU:      k8sresource.MustParse("200m"),
+					corev1.ResourceLimitsMemory:   k8sresource.MustParse("100Mi"),
+					corev1.ResourceRequestsMemory: k8sresource.MustParse("90Mi"),
 				},
-				{
-					NamespaceName: fmt.Sprintf("rl-quota-%s", "local-path-storage"),
-					CpuRequest:    "0/0.25",
-					CpuLimit:      "0/0.5",
-					MemLimit:      "0/150Mi",
-					MemRequest:    "0/120Mi",
+				"local-path-storage": {
+					corev1.ResourceRequestsCPU:    k8sresource.MustParse("0"),
+					corev1.ResourceLimitsCPU:      k8sresource.MustParse("0"),
+					corev1.ResourceLimitsMemory:   k8sresource.MustParse("0"),
+					corev1.ResourceRequestsMemory: k8sresource.MustParse("0"),
 				},
 			}
 
-			Expect(reflect.DeepEqual(existingResourceLimiter3.Status.Quotas, targetQuotas)).Should(Equal(true))
+			Expect(sameUsage(existingResourceLimiter3.Status, targetUsage)).Should(Equal(true))
 
 			// By("By checking all the related quotas after updating the new rls")
 			// updatedrl := existingResourceLimiter3.DeepCopy()
@@ -652,24 +678,1029 @@ var _ = Describe("ResourceLimiter controller", func() {
 				}
 				return existingResourceLimiter5.Status.State
 			}, timeout, interval).Should(Equal(constants.Ready))
-			targetQuotas = []rlv1beta2.ResourceLimiterQuota{
-				{
-					NamespaceName: fmt.Sprintf("rl-quota-%s", "default"),
-					CpuRequest:    "0/0.25",
-					CpuLimit:      "0/0.5",
-					MemLimit:      "0/150Mi",
-					MemRequest:    "0/120Mi",
+			targetUsage = map[string]corev1.ResourceList{
+				"default": {
+					corev1.ResourceRequestsCPU:    k8sresource.MustParse("0"),
+					corev1.ResourceLimitsCPU:      k8sresource.MustParse("0"),
+					corev1.ResourceLimitsMemory:   k8sresource.MustParse("0"),
+					corev1.ResourceRequestsMemory: k8sresource.MustParse("0"),
+				},
+				"local-path-storage": {
+					corev1.ResourceRequestsCPU:    k8sresource.MustParse("0"),
+					corev1.ResourceLimitsCPU:      k8sresource.MustParse("0"),
+					corev1.ResourceLimitsMemory:   k8sresource.MustParse("0"),
+					corev1.ResourceRequestsMemory: k8sresource.MustParse("0"),
+				},
+			}
+			Expect(sameUsage(existingResourceLimiter5.Status, targetUsage)).Should(Equal(true))
+
+		})
+	})
+	Context("ResourceLimiter Namespace Selector", func() {
+		rl := &rlv1beta2.ResourceLimiter{}
+		content, err := ioutil.ReadFile(filepath.Join(pwd, "fixtures/fixtures_cr_selector.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		err = yaml.Unmarshal(content, rl)
+		Expect(err).NotTo(HaveOccurred())
+
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-selector-fixtures",
+				Labels: map[string]string{
+					"resourcelimiter-fixtures": "selected",
+				},
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should follow the labels of namespaces", func() {
+			By("By creating a new ResourceLimiter and a labelled namespace")
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+
+			By("By checking the quota of the selected namespace")
+			namespacedName := types.NamespacedName{Name: fmt.Sprintf("rl-quota-%s", namespace.Name), Namespace: namespace.Name}
+			Eventually(func() bool {
+				resourceQuota := &corev1.ResourceQuota{}
+				return k8sClient.Get(ctx, namespacedName, resourceQuota) == nil
+			}, timeout, interval).Should(Equal(true))
+
+			var existingResourceLimiter1 rlv1beta2.ResourceLimiter
+			Eventually(func() []string {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rl), &existingResourceLimiter1); err != nil || len(existingResourceLimiter1.Status.Selected) == 0 {
+					return nil
+				}
+				return existingResourceLimiter1.Status.Selected[0].Namespaces
+			}, timeout, interval).Should(ContainElement(namespace.Name))
+
+			By("By removing the label from the namespace")
+			existingNamespace := &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(namespace), existingNamespace)).Should(Succeed())
+			delete(existingNamespace.Labels, "resourcelimiter-fixtures")
+			Expect(k8sClient.Update(ctx, existingNamespace)).Should(Succeed())
+
+			Eventually(func() bool {
+				resourceQuota := &corev1.ResourceQuota{}
+				return apierrors.IsNotFound(k8sClient.Get(ctx, namespacedName, resourceQuota))
+			}, timeout, interval).Should(Equal(true))
+		})
+	})
+	Context("ResourceLimiter Conditions", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-conditions",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-conditions-fixtures",
+						CpuLimit:      "200m",
+						CpuRequest:    "100m",
+					},
+				},
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-conditions-fixtures",
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should report the missing namespace and become ready once it exists", func() {
+			By("By creating a ResourceLimiter targeting a missing namespace")
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			var existingResourceLimiter1 rlv1beta2.ResourceLimiter
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rl), &existingResourceLimiter1); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionTrue(existingResourceLimiter1.Status.Conditions, constants.ConditionNamespaceMissing)
+			}, timeout, interval).Should(Equal(true))
+			missing := meta.FindStatusCondition(existingResourceLimiter1.Status.Conditions, constants.ConditionNamespaceMissing)
+			Expect(missing.Reason).Should(Equal(constants.ReasonNamespaceNotFound))
+			Expect(missing.Message).Should(ContainSubstring(namespace.Name))
+			Expect(meta.IsStatusConditionFalse(existingResourceLimiter1.Status.Conditions, constants.ConditionReady)).Should(Equal(true))
+
+			By("By creating the namespace")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+
+			var existingResourceLimiter2 rlv1beta2.ResourceLimiter
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rl), &existingResourceLimiter2); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionTrue(existingResourceLimiter2.Status.Conditions, constants.ConditionReady)
+			}, timeout, interval).Should(Equal(true))
+			Expect(existingResourceLimiter2.Status.ObservedGeneration).Should(Equal(existingResourceLimiter2.Generation))
+			Expect(meta.IsStatusConditionFalse(existingResourceLimiter2.Status.Conditions, constants.ConditionNamespaceMissing)).Should(Equal(true))
+		})
+	})
+	Context("ResourceLimiter Partial Failure", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-partial",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-partial-missing",
+						CpuLimit:      "200m",
+					},
+					{
+						NamespaceName: "rl-partial-fixtures",
+						CpuLimit:      "200m",
+					},
+				},
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-partial-fixtures",
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should create the quotas of the other namespaces when one namespace is missing", func() {
+			By("By creating a ResourceLimiter whose first namespace is missing")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			namespacedName := types.NamespacedName{Name: fmt.Sprintf("rl-quota-%s", namespace.Name), Namespace: namespace.Name}
+			Eventually(func() bool {
+				resourceQuota := &corev1.ResourceQuota{}
+				return k8sClient.Get(ctx, namespacedName, resourceQuota) == nil
+			}, timeout, interval).Should(Equal(true))
+
+			var existingResourceLimiter1 rlv1beta2.ResourceLimiter
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rl), &existingResourceLimiter1); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionTrue(existingResourceLimiter1.Status.Conditions, constants.ConditionNamespaceMissing) && len(existingResourceLimiter1.Status.Namespaces) == 1
+			}, timeout, interval).Should(Equal(true))
+			Expect(existingResourceLimiter1.Status.Namespaces[0].Namespace).Should(Equal(namespace.Name))
+			Expect(meta.FindStatusCondition(existingResourceLimiter1.Status.Conditions, constants.ConditionNamespaceMissing).Message).Should(ContainSubstring("rl-partial-missing"))
+		})
+	})
+	Context("ResourceLimiter Orphaned Quotas", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-orphans",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-orphans-kept",
+						CpuLimit:      "200m",
+					},
+					{
+						NamespaceName: "rl-orphans-removed",
+						CpuLimit:      "200m",
+					},
+				},
+			},
+		}
+		namespaces := []*corev1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-orphans-kept"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-orphans-removed"}},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			for _, namespace := range namespaces {
+				Eventually(func() bool {
+					if err := k8sClient.Delete(ctx, namespace); err != nil {
+						return apierrors.IsNotFound(err)
+					}
+					return false
+				}, timeout, interval).Should(Equal(true))
+			}
+		})
+
+		It("Should delete the quota of a namespace removed from the spec", func() {
+			By("By creating a ResourceLimiter with two namespaces")
+			for _, namespace := range namespaces {
+				Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			}
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			removedQuota := types.NamespacedName{Name: "rl-quota-rl-orphans-removed", Namespace: "rl-orphans-removed"}
+			resourceQuota := &corev1.ResourceQuota{}
+			Eventually(func() bool {
+				return k8sClient.Get(ctx, removedQuota, resourceQuota) == nil
+			}, timeout, interval).Should(Equal(true))
+			Expect(resourceQuota.Labels[constants.OwnerLabel]).Should(Equal(rl.Name))
+
+			By("By removing the namespace from the spec")
+			existingResourceLimiter1 := &rlv1beta2.ResourceLimiter{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(rl), existingResourceLimiter1)).Should(Succeed())
+			existingResourceLimiter1.Spec.Quotas = existingResourceLimiter1.Spec.Quotas[:1]
+			Expect(k8sClient.Update(ctx, existingResourceLimiter1)).Should(Succeed())
+
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, removedQuota, &corev1.ResourceQuota{}))
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() map[string]string {
+				existingNamespace := &corev1.Namespace{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "rl-orphans-removed"}, existingNamespace); err != nil {
+					return nil
+				}
+				return existingNamespace.Labels
+			}, timeout, interval).ShouldNot(HaveKey(constants.MutateNamespaceLabel))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "rl-quota-rl-orphans-kept", Namespace: "rl-orphans-kept"}, &corev1.ResourceQuota{})).Should(Succeed())
+		})
+	})
+	Context("ResourceLimiter Conflicts", func() {
+		newResourceLimiter := func(name string, priority int32, cpuLimit string) *rlv1beta2.ResourceLimiter {
+			return &rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: name,
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Applied:  true,
+					Priority: priority,
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "rl-conflict-fixtures",
+							CpuLimit:      cpuLimit,
+						},
+					},
+				},
+			}
+		}
+		lowResourceLimiter := newResourceLimiter("resourcelimiter-conflict-low", 1, "1")
+		highResourceLimiter := newResourceLimiter("resourcelimiter-conflict-high", 10, "500m")
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-conflict-fixtures",
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			for _, rl := range []*rlv1beta2.ResourceLimiter{lowResourceLimiter, highResourceLimiter} {
+				Eventually(func() bool {
+					if err := k8sClient.Delete(ctx, rl); err != nil {
+						return apierrors.IsNotFound(err)
+					}
+					return false
+				}, timeout, interval).Should(Equal(true))
+			}
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should leave the namespace to the ResourceLimiter with the highest priority", func() {
+			By("By creating two ResourceLimiters targeting the same namespace")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, lowResourceLimiter)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, highResourceLimiter)).Should(Succeed())
+
+			namespacedName := types.NamespacedName{Name: "rl-quota-rl-conflict-fixtures", Namespace: namespace.Name}
+			Eventually(func() string {
+				resourceQuota := &corev1.ResourceQuota{}
+				if err := k8sClient.Get(ctx, namespacedName, resourceQuota); err != nil {
+					return ""
+				}
+				return resourceQuota.Labels[constants.OwnerLabel]
+			}, timeout, interval).Should(Equal(highResourceLimiter.Name))
+
+			var existingResourceLimiter1 rlv1beta2.ResourceLimiter
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(lowResourceLimiter), &existingResourceLimiter1); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionTrue(existingResourceLimiter1.Status.Conditions, constants.ConditionQuotaConflict)
+			}, timeout, interval).Should(Equal(true))
+			Expect(meta.FindStatusCondition(existingResourceLimiter1.Status.Conditions, constants.ConditionQuotaConflict).Message).Should(ContainSubstring(highResourceLimiter.Name))
+
+			resourceQuota := &corev1.ResourceQuota{}
+			Expect(k8sClient.Get(ctx, namespacedName, resourceQuota)).Should(Succeed())
+			Expect(resourceQuota.Spec.Hard[corev1.ResourceLimitsCPU]).Should(Equal(k8sresource.MustParse("500m")))
+
+			By("By deleting the ResourceLimiter with the lowest priority")
+			Expect(k8sClient.Delete(ctx, lowResourceLimiter)).Should(Succeed())
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(lowResourceLimiter), &rlv1beta2.ResourceLimiter{}))
+			}, timeout, interval).Should(Equal(true))
+			// The namespace is still limited by the other ResourceLimiter, so it keeps its labels and quota
+			existingNamespace := &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: namespace.Name}, existingNamespace)).Should(Succeed())
+			Expect(existingNamespace.Labels).Should(HaveKey(constants.MutateNamespaceLabel))
+			Expect(existingNamespace.Labels).Should(HaveKey(constants.ValidateNamespaceLabel))
+			Expect(k8sClient.Get(ctx, namespacedName, &corev1.ResourceQuota{})).Should(Succeed())
+		})
+	})
+	Context("ResourceLimiter Drift", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-drift",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied:     true,
+				DriftPolicy: rlv1beta2.DriftPolicyWarn,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-drift-fixtures",
+						CpuLimit:      "500m",
+					},
+				},
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-drift-fixtures",
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should report a hand edited quota under Warn and revert it under Enforce", func() {
+			By("By creating a ResourceLimiter with the Warn drift policy")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			namespacedName := types.NamespacedName{Name: "rl-quota-rl-drift-fixtures", Namespace: namespace.Name}
+			resourceQuota := &corev1.ResourceQuota{}
+			Eventually(func() bool {
+				return k8sClient.Get(ctx, namespacedName, resourceQuota) == nil
+			}, timeout, interval).Should(Equal(true))
+
+			By("By editing the quota by hand")
+			resourceQuota.Spec.Hard[corev1.ResourceLimitsCPU] = k8sresource.MustParse("4")
+			Expect(k8sClient.Update(ctx, resourceQuota)).Should(Succeed())
+
+			var existingResourceLimiter1 rlv1beta2.ResourceLimiter
+			Eventually(func() int {
+				if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(rl), &existingResourceLimiter1); err != nil || len(existingResourceLimiter1.Status.Namespaces) == 0 {
+					return 0
+				}
+				return len(existingResourceLimiter1.Status.Namespaces[0].Drift)
+			}, timeout, interval).Should(Equal(1))
+			Expect(k8sClient.Get(ctx, namespacedName, resourceQuota)).Should(Succeed())
+			Expect(resourceQuota.Spec.Hard[corev1.ResourceLimitsCPU]).Should(Equal(k8sresource.MustParse("4")))
+
+			By("By switching to the Enforce drift policy")
+			existingResourceLimiter1.Spec.DriftPolicy = rlv1beta2.DriftPolicyEnforce
+			Expect(k8sClient.Update(ctx, &existingResourceLimiter1)).Should(Succeed())
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, namespacedName, resourceQuota); err != nil {
+					return ""
+				}
+				value := resourceQuota.Spec.Hard[corev1.ResourceLimitsCPU]
+				return value.String()
+			}, timeout, interval).Should(Equal("500m"))
+		})
+	})
+	Context("ResourceLimiter Events", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-events",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-events-fixtures",
+						CpuLimit:      "200m",
+					},
+				},
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-events-fixtures",
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should record the quota creation on the namespace", func() {
+			By("By creating a ResourceLimiter and its namespace")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			Eventually(func() []string {
+				events := &corev1.EventList{}
+				if err := k8sClient.List(ctx, events); err != nil {
+					return nil
+				}
+				var reasons []string
+				for _, event := range events.Items {
+					if event.InvolvedObject.Kind == "Namespace" && event.InvolvedObject.Name == namespace.Name {
+						reasons = append(reasons, event.Reason)
+					}
+				}
+				return reasons
+			}, timeout, interval).Should(ContainElement(constants.EventReasonQuotaCreated))
+		})
+	})
+	Context("ResourceLimiter Metrics", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-metrics",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-metrics-fixtures",
+						CpuLimit:      "200m",
+					},
+				},
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-metrics-fixtures",
+			},
+		}
+		ctx := context.Background()
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should export the quota usage and forget it once the ResourceLimiter is deleted", func() {
+			By("By creating a ResourceLimiter and its namespace")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			Eventually(func() float64 {
+				return testutil.ToFloat64(quotaHard.WithLabelValues(rl.Name, namespace.Name, string(corev1.ResourceLimitsCPU)))
+			}, timeout, interval).Should(Equal(0.2))
+			Expect(testutil.ToFloat64(reconcileTotal.WithLabelValues(rl.Name, reconcileSuccess))).Should(BeNumerically(">", 0))
+
+			By("By deleting the ResourceLimiter")
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				usageSeries.Lock()
+				defer usageSeries.Unlock()
+				_, ok := usageSeries.labels[rl.Name]
+				return ok
+			}, timeout, interval).Should(Equal(false))
+		})
+	})
+	Context("ResourceLimiter LimitRange", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-limitrange",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-limitrange-fixtures",
+						CpuLimit:      "2",
+						LimitRange: &corev1.LimitRangeSpec{
+							Limits: []corev1.LimitRangeItem{
+								{
+									Type: corev1.LimitTypeContainer,
+									Default: corev1.ResourceList{
+										corev1.ResourceCPU: k8sresource.MustParse("200m"),
+									},
+									DefaultRequest: corev1.ResourceList{
+										corev1.ResourceCPU: k8sresource.MustParse("100m"),
+									},
+								},
+							},
+						},
+					},
 				},
-				{
-					NamespaceName: fmt.Sprintf("rl-quota-%s", "local-path-storage"),
-					CpuRequest:    "0/0.25",
-					CpuLimit:      "0/0.5",
-					MemLimit:      "0/150Mi",
-					MemRequest:    "0/120Mi",
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-limitrange-fixtures",
+			},
+		}
+		ctx := context.Background()
+		limitRangeKey := types.NamespacedName{Namespace: "rl-limitrange-fixtures", Name: "rl-limitrange-rl-limitrange-fixtures"}
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should create the limit range with the quota and delete it with the ResourceLimiter", func() {
+			By("By creating a ResourceLimiter with a limitRange")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			limitRange := &corev1.LimitRange{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, limitRangeKey, limitRange)
+			}, timeout, interval).Should(Succeed())
+			Expect(limitRange.Labels[constants.OwnerLabel]).To(Equal(rl.Name))
+			Expect(limitRange.Spec.Limits).To(HaveLen(1))
+			Expect(limitRange.Spec.Limits[0].Default.Cpu().String()).To(Equal("200m"))
+
+			Eventually(func() string {
+				current := &rlv1beta2.ResourceLimiter{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, current); err != nil || len(current.Status.Namespaces) != 1 || current.Status.Namespaces[0].LimitRange == nil {
+					return ""
+				}
+				return current.Status.Namespaces[0].LimitRange.Name
+			}, timeout, interval).Should(Equal(limitRangeKey.Name))
+
+			By("By deleting the ResourceLimiter")
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, limitRangeKey, &corev1.LimitRange{}))
+			}, timeout, interval).Should(Equal(true))
+		})
+	})
+	Context("ResourceLimiter LimitRange Not Applied", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-limitrange-not-applied",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: false,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceName: "rl-limitrange-not-applied-fixtures",
+						CpuLimit:      "2",
+						LimitRange: &corev1.LimitRangeSpec{
+							Limits: []corev1.LimitRangeItem{
+								{
+									Type: corev1.LimitTypeContainer,
+									Default: corev1.ResourceList{
+										corev1.ResourceCPU: k8sresource.MustParse("200m"),
+									},
+								},
+							},
+						},
+					},
 				},
+			},
+		}
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "rl-limitrange-not-applied-fixtures",
+			},
+		}
+		ctx := context.Background()
+		limitRangeKey := types.NamespacedName{Namespace: "rl-limitrange-not-applied-fixtures", Name: "rl-limitrange-rl-limitrange-not-applied-fixtures"}
+		reconciled := func() bool {
+			current := &rlv1beta2.ResourceLimiter{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, current); err != nil {
+				return false
 			}
-			Expect(reflect.DeepEqual(existingResourceLimiter5.Status.Quotas, targetQuotas)).Should(Equal(true))
+			return current.Status.ObservedGeneration == current.Generation
+		}
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
 
+		It("Should only keep the limit range while the ResourceLimiter is applied", func() {
+			By("By creating a ResourceLimiter which is not applied")
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+			Eventually(reconciled, timeout, interval).Should(Equal(true))
+			Expect(apierrors.IsNotFound(k8sClient.Get(ctx, limitRangeKey, &corev1.LimitRange{}))).Should(Equal(true))
+
+			By("By applying the ResourceLimiter")
+			existingResourceLimiter := &rlv1beta2.ResourceLimiter{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, existingResourceLimiter)).Should(Succeed())
+			existingResourceLimiter.Spec.Applied = true
+			Expect(k8sClient.Update(ctx, existingResourceLimiter)).Should(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(ctx, limitRangeKey, &corev1.LimitRange{})
+			}, timeout, interval).Should(Succeed())
+
+			By("By unapplying the ResourceLimiter")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, existingResourceLimiter); err != nil {
+					return err
+				}
+				existingResourceLimiter.Spec.Applied = false
+				return k8sClient.Update(ctx, existingResourceLimiter)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, limitRangeKey, &corev1.LimitRange{}))
+			}, timeout, interval).Should(Equal(true))
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace.Name, Name: "rl-quota-" + namespace.Name}, &corev1.ResourceQuota{}))
+			}, timeout, interval).Should(Equal(true))
+		})
+	})
+	Context("ResourceLimiter Budget", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-budget",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rl-budget": "team-x"}},
+						MemLimit:          "1Gi",
+					},
+				},
+				Budget: &rlv1beta2.ResourceBudget{
+					Hard:      corev1.ResourceList{corev1.ResourceLimitsCPU: k8sresource.MustParse("10")},
+					Split:     rlv1beta2.BudgetSplitWeighted,
+					WeightKey: "rl-budget/weight",
+				},
+			},
+		}
+		newNamespace := func(name, weight string) *corev1.Namespace {
+			namespace := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{"rl-budget": "team-x"},
+				},
+			}
+			if weight != "" {
+				namespace.Annotations = map[string]string{"rl-budget/weight": weight}
+			}
+			return namespace
+		}
+		namespaces := []*corev1.Namespace{
+			newNamespace("rl-budget-fixtures-a", "2"),
+			newNamespace("rl-budget-fixtures-b", "1"),
+			newNamespace("rl-budget-fixtures-c", ""),
+		}
+		ctx := context.Background()
+		cpuLimits := func() map[string]string {
+			limits := map[string]string{}
+			for _, namespace := range namespaces {
+				resourceQuota := &corev1.ResourceQuota{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace.Name, Name: fmt.Sprintf("rl-quota-%s", namespace.Name)}, resourceQuota); err == nil {
+					limits[namespace.Name] = resourceQuota.Spec.Hard.Name(corev1.ResourceLimitsCPU, k8sresource.DecimalSI).String()
+				}
+			}
+			return limits
+		}
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			for _, namespace := range namespaces {
+				Eventually(func() bool {
+					if err := k8sClient.Delete(ctx, namespace); err != nil {
+						return apierrors.IsNotFound(err)
+					}
+					return false
+				}, timeout, interval).Should(Equal(true))
+			}
+		})
+
+		It("Should divide the budget by weight and divide it again when a namespace leaves", func() {
+			By("By creating a ResourceLimiter with a budget over three namespaces")
+			for _, namespace := range namespaces {
+				Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			}
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+
+			Eventually(cpuLimits, timeout, interval).Should(Equal(map[string]string{
+				"rl-budget-fixtures-a": "5",
+				"rl-budget-fixtures-b": "2500m",
+				"rl-budget-fixtures-c": "2500m",
+			}))
+			resourceQuota := &corev1.ResourceQuota{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "rl-budget-fixtures-a", Name: "rl-quota-rl-budget-fixtures-a"}, resourceQuota)).Should(Succeed())
+			Expect(resourceQuota.Spec.Hard[corev1.ResourceLimitsMemory]).Should(Equal(k8sresource.MustParse("1Gi")))
+
+			By("By removing a namespace from the selector")
+			Eventually(func() error {
+				namespace := &corev1.Namespace{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "rl-budget-fixtures-c"}, namespace); err != nil {
+					return err
+				}
+				delete(namespace.Labels, "rl-budget")
+				return k8sClient.Update(ctx, namespace)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(cpuLimits, timeout, interval).Should(Equal(map[string]string{
+				"rl-budget-fixtures-a": "6667m",
+				"rl-budget-fixtures-b": "3333m",
+			}))
+			current := &rlv1beta2.ResourceLimiter{}
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, current); err != nil || current.Status.Budget == nil || len(current.Status.Budget.Shares) != 2 {
+					return ""
+				}
+				return current.Status.Budget.Allocated.Name(corev1.ResourceLimitsCPU, k8sresource.DecimalSI).String()
+			}, timeout, interval).Should(Equal("10"))
+		})
+
+		It("Should never hand out more than the total", func() {
+			for _, weights := range [][]int64{{1, 1, 1}, {2, 1, 1}, {0, 0}, {7, 0, 3}, {1}} {
+				bigWeights := make([]*big.Int, 0, len(weights))
+				for _, weight := range weights {
+					bigWeights = append(bigWeights, big.NewInt(weight))
+				}
+				total := big.NewInt(10000)
+				sum := new(big.Int)
+				for _, part := range splitUnits(total, bigWeights) {
+					Expect(part.Sign()).ShouldNot(Equal(-1))
+					sum.Add(sum, part)
+				}
+				Expect(sum.Cmp(total)).Should(Equal(0))
+			}
+		})
+	})
+	Context("ResourceLimiter Budget Rebalance", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-rebalance",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied: true,
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{NamespaceName: "rl-rebalance-fixtures-idle"},
+					{NamespaceName: "rl-rebalance-fixtures-busy"},
+				},
+				Budget: &rlv1beta2.ResourceBudget{
+					Hard: corev1.ResourceList{corev1.ResourceLimitsCPU: k8sresource.MustParse("4")},
+					Rebalance: &rlv1beta2.BudgetRebalance{
+						Interval: metav1.Duration{Duration: 2 * time.Second},
+						Floor:    corev1.ResourceList{corev1.ResourceLimitsCPU: k8sresource.MustParse("500m")},
+						MaxStep:  corev1.ResourceList{corev1.ResourceLimitsCPU: k8sresource.MustParse("1")},
+					},
+				},
+			},
+		}
+		namespaces := []*corev1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-rebalance-fixtures-idle"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-rebalance-fixtures-busy"}},
+		}
+		ctx := context.Background()
+		quotaKey := func(ns string) types.NamespacedName {
+			return types.NamespacedName{Namespace: ns, Name: fmt.Sprintf("rl-quota-%s", ns)}
+		}
+		cpuLimit := func(ns string) func() string {
+			return func() string {
+				resourceQuota := &corev1.ResourceQuota{}
+				if err := k8sClient.Get(ctx, quotaKey(ns), resourceQuota); err != nil {
+					return ""
+				}
+				return resourceQuota.Spec.Hard.Name(corev1.ResourceLimitsCPU, k8sresource.DecimalSI).String()
+			}
+		}
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			for _, namespace := range namespaces {
+				Eventually(func() bool {
+					if err := k8sClient.Delete(ctx, namespace); err != nil {
+						return apierrors.IsNotFound(err)
+					}
+					return false
+				}, timeout, interval).Should(Equal(true))
+			}
+		})
+
+		It("Should move the headroom of the idle namespace to the busy one", func() {
+			By("By creating a ResourceLimiter rebalancing its budget")
+			for _, namespace := range namespaces {
+				Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			}
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+			for _, namespace := range namespaces {
+				Eventually(cpuLimit(namespace.Name), timeout, interval).Should(Equal("2"))
+			}
+
+			By("By reporting the usage of the namespaces")
+			for ns, used := range map[string]string{"rl-rebalance-fixtures-idle": "100m", "rl-rebalance-fixtures-busy": "1900m"} {
+				resourceQuota := &corev1.ResourceQuota{}
+				Expect(k8sClient.Get(ctx, quotaKey(ns), resourceQuota)).Should(Succeed())
+				resourceQuota.Status.Hard = resourceQuota.Spec.Hard.DeepCopy()
+				resourceQuota.Status.Used = corev1.ResourceList{corev1.ResourceLimitsCPU: k8sresource.MustParse(used)}
+				Expect(k8sClient.Status().Update(ctx, resourceQuota)).Should(Succeed())
+			}
+
+			Eventually(cpuLimit("rl-rebalance-fixtures-busy"), timeout, interval).Should(Equal("3"))
+			Expect(cpuLimit("rl-rebalance-fixtures-idle")()).Should(Equal("1"))
+			current := &rlv1beta2.ResourceLimiter{}
+			Eventually(func() int {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, current); err != nil || current.Status.Budget == nil {
+					return 0
+				}
+				return len(current.Status.Budget.Adjustments)
+			}, timeout, interval).Should(Equal(2))
+			Expect(current.Status.Budget.Allocated.Name(corev1.ResourceLimitsCPU, k8sresource.DecimalSI).String()).Should(Equal("4"))
+			Expect(current.Status.Budget.Adjustments[1].Namespace).Should(Equal("rl-rebalance-fixtures-busy"))
+			Expect(current.Status.Budget.Adjustments[1].To.String()).Should(Equal("3"))
+		})
+	})
+
+	Context("ResourceLimiter Capacity", func() {
+		rl := &rlv1beta2.ResourceLimiter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "resourcelimiter-capacity",
+			},
+			Spec: rlv1beta2.ResourceLimiterSpec{
+				Applied:      true,
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rl-capacity-fixtures": "pool"}},
+				Quotas: []rlv1beta2.ResourceLimiterQuota{
+					{NamespaceName: "rl-capacity-fixtures", CpuLimit: "25%", MemLimit: "50%", CpuRequest: "1"},
+				},
+			},
+		}
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "rl-capacity-fixtures"}}
+		nodes := []*corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-capacity-fixtures-a", Labels: map[string]string{"rl-capacity-fixtures": "pool"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-capacity-fixtures-b", Labels: map[string]string{"rl-capacity-fixtures": "pool"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rl-capacity-fixtures-other"}},
+		}
+		ctx := context.Background()
+		createNode := func(node *corev1.Node) {
+			Expect(k8sClient.Create(ctx, node)).Should(Succeed())
+			node.Status.Allocatable = corev1.ResourceList{
+				corev1.ResourceCPU:    k8sresource.MustParse("8"),
+				corev1.ResourceMemory: k8sresource.MustParse("16Gi"),
+			}
+			Expect(k8sClient.Status().Update(ctx, node)).Should(Succeed())
+		}
+		hard := func(name corev1.ResourceName) func() string {
+			return func() string {
+				resourceQuota := &corev1.ResourceQuota{}
+				key := types.NamespacedName{Namespace: namespace.Name, Name: fmt.Sprintf("rl-quota-%s", namespace.Name)}
+				if err := k8sClient.Get(ctx, key, resourceQuota); err != nil {
+					return ""
+				}
+				value := resourceQuota.Spec.Hard[name]
+				return value.String()
+			}
+		}
+
+		JustAfterEach(func() {
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, rl); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+			for _, node := range nodes {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, node))).Should(Succeed())
+			}
+			Eventually(func() bool {
+				if err := k8sClient.Delete(ctx, namespace); err != nil {
+					return apierrors.IsNotFound(err)
+				}
+				return false
+			}, timeout, interval).Should(Equal(true))
+		})
+
+		It("Should resolve the percentages against the selected nodes and follow them", func() {
+			By("By creating a ResourceLimiter taking percentages of one node")
+			createNode(nodes[0])
+			createNode(nodes[2])
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, rl)).Should(Succeed())
+			Eventually(hard(corev1.ResourceLimitsCPU), timeout, interval).Should(Equal("2"))
+			Expect(hard(corev1.ResourceLimitsMemory)()).Should(Equal("8Gi"))
+			Expect(hard(corev1.ResourceRequestsCPU)()).Should(Equal("1"))
+
+			By("By adding a node to the pool")
+			createNode(nodes[1])
+			Eventually(hard(corev1.ResourceLimitsCPU), timeout, interval).Should(Equal("4"))
+			Expect(hard(corev1.ResourceLimitsMemory)()).Should(Equal("16Gi"))
+			current := &rlv1beta2.ResourceLimiter{}
+			Eventually(func() int {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: rl.Name}, current); err != nil || current.Status.Capacity == nil {
+					return 0
+				}
+				return current.Status.Capacity.Nodes
+			}, timeout, interval).Should(Equal(2))
+			Expect(current.Status.Capacity.Resolved).Should(HaveLen(1))
+			resolved := current.Status.Capacity.Resolved[0].Hard[corev1.ResourceLimitsCPU]
+			Expect(resolved.String()).Should(Equal("4"))
+
+			By("By removing the node from the pool")
+			Expect(k8sClient.Delete(ctx, nodes[1])).Should(Succeed())
+			Eventually(hard(corev1.ResourceLimitsCPU), timeout, interval).Should(Equal("2"))
 		})
 	})
 })
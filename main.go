@@ -32,6 +32,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	resourcesv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	resourcesv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	resourcesv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
 	"github.com/chenliu1993/resourcelimiter/controllers"
@@ -48,6 +49,7 @@ func init() {
 
 	utilruntime.Must(resourcesv1beta1.AddToScheme(scheme))
 	utilruntime.Must(resourcesv1beta2.AddToScheme(scheme))
+	utilruntime.Must(resourcesv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -95,8 +97,9 @@ func main() {
 	}
 
 	if err = (&controllers.ResourceLimiterReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("resourcelimiter-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ResourceLimiter")
 		os.Exit(1)
@@ -0,0 +1,59 @@
+package capacity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var percentPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?%$`)
+
+// IsPercent reports whether a cpu or memory shorthand of spec.targets is a percentage, e.g. "15%"
+func IsPercent(value string) bool {
+	return strings.HasSuffix(value, "%")
+}
+
+// ParsePercent parses a percentage greater than 0% and at most 100%, decimals are allowed
+func ParsePercent(value string) (*inf.Dec, error) {
+	if !percentPattern.MatchString(value) {
+		return nil, fmt.Errorf("percentage %q should be a decimal number followed by %%", value)
+	}
+	percent, _ := new(inf.Dec).SetString(strings.TrimSuffix(value, "%"))
+	if percent.Sign() <= 0 || percent.Cmp(inf.NewDec(100, 0)) > 0 {
+		return nil, fmt.Errorf("percentage %q should be greater than 0%% and at most 100%%", value)
+	}
+	return percent, nil
+}
+
+// Allocatable sums the allocatable cpu and memory of the nodes
+func Allocatable(nodes []corev1.Node) corev1.ResourceList {
+	allocatable := corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewQuantity(0, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(0, resource.BinarySI),
+	}
+	for _, node := range nodes {
+		for name, total := range allocatable {
+			if value, ok := node.Status.Allocatable[name]; ok {
+				total.Add(value)
+				allocatable[name] = total
+			}
+		}
+	}
+	return allocatable
+}
+
+// Resolve returns the percentage of the allocatable amount of a resource,
+// rounded down to the millicore for cpu and to the byte for memory
+func Resolve(percent *inf.Dec, allocatable resource.Quantity, name corev1.ResourceName) resource.Quantity {
+	scale := inf.Scale(0)
+	if name == corev1.ResourceCPU {
+		scale = 3
+	}
+	amount := new(inf.Dec).Mul(allocatable.AsDec(), percent)
+	amount.QuoRound(amount, inf.NewDec(100, 0), scale, inf.RoundDown)
+	return *resource.NewDecimalQuantity(*amount, allocatable.Format)
+}
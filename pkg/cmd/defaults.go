@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+)
+
+// defaultsKey is the key of the defaults ConfigMap holding the mutation defaults
+const defaultsKey = "defaults.yaml"
+
+// quotaDefaults are the quota values filled into the targets missing them
+type quotaDefaults struct {
+	CpuRequest string `json:"cpu_requests,omitempty"`
+	CpuLimit   string `json:"cpu_limits,omitempty"`
+	MemRequest string `json:"mem_requests,omitempty"`
+	MemLimit   string `json:"mem_limits,omitempty"`
+}
+
+// defaultsOverride replaces some of the quota defaults for the namespaces its selector matches
+type defaultsOverride struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	Quota             quotaDefaults         `json:"quota"`
+}
+
+// mutationDefaults are what the mutating webhook fills into ResourceLimiters, loaded from the defaults ConfigMap:
+//
+//	namespace: default
+//	quota:
+//	  cpu_requests: "1"
+//	  cpu_limits: "2"
+//	overrides:
+//	- namespaceSelector:
+//	    matchLabels:
+//	      env: prod
+//	  quota:
+//	    cpu_limits: "4"
+type mutationDefaults struct {
+	// Namespace is the target added to a ResourceLimiter without any
+	Namespace string             `json:"namespace,omitempty"`
+	Quota     quotaDefaults      `json:"quota,omitempty"`
+	Overrides []defaultsOverride `json:"overrides,omitempty"`
+}
+
+// builtinDefaults are used when the defaults ConfigMap is missing, unreadable or leaves a value unset
+var builtinDefaults = mutationDefaults{
+	Namespace: "default",
+	Quota: quotaDefaults{
+		CpuRequest: "1",
+		CpuLimit:   "2",
+		MemRequest: "150Mi",
+		MemLimit:   "200Mi",
+	},
+}
+
+// merge returns the defaults with the values the override sets
+func (q quotaDefaults) merge(override quotaDefaults) quotaDefaults {
+	for _, field := range []struct {
+		value    *string
+		override string
+	}{{&q.CpuRequest, override.CpuRequest}, {&q.CpuLimit, override.CpuLimit}, {&q.MemRequest, override.MemRequest}, {&q.MemLimit, override.MemLimit}} {
+		if field.override != "" {
+			*field.value = field.override
+		}
+	}
+	return q
+}
+
+func (q quotaDefaults) validate() error {
+	for _, value := range []string{q.CpuRequest, q.CpuLimit, q.MemRequest, q.MemLimit} {
+		if value == "" {
+			continue
+		}
+		if _, err := k8sresource.ParseQuantity(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseDefaults decodes the defaults of the ConfigMap over the builtin ones
+func parseDefaults(data string) (*mutationDefaults, error) {
+	parsed := mutationDefaults{}
+	if err := yaml.UnmarshalStrict([]byte(data), &parsed); err != nil {
+		return nil, err
+	}
+	defaults := builtinDefaults
+	if parsed.Namespace != "" {
+		defaults.Namespace = parsed.Namespace
+	}
+	defaults.Quota = defaults.Quota.merge(parsed.Quota)
+	if err := defaults.Quota.validate(); err != nil {
+		return nil, fmt.Errorf("quota: %v", err)
+	}
+	for i, override := range parsed.Overrides {
+		if override.NamespaceSelector == nil {
+			return nil, fmt.Errorf("overrides[%d] should set a namespaceSelector", i)
+		}
+		if _, err := metav1.LabelSelectorAsSelector(override.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("overrides[%d]: %v", i, err)
+		}
+		if err := override.Quota.validate(); err != nil {
+			return nil, fmt.Errorf("overrides[%d].quota: %v", i, err)
+		}
+	}
+	defaults.Overrides = parsed.Overrides
+	return &defaults, nil
+}
+
+// mutationDefaults returns the defaults of the ConfigMap watched by the webhook, the builtin ones without it.
+// The ConfigMap is read from the informer cache so edits apply without restarting the webhook.
+func (whsvr *WebhookServer) mutationDefaults() *mutationDefaults {
+	if whsvr.configMaps == nil {
+		return &builtinDefaults
+	}
+	configMap, err := whsvr.configMaps.ConfigMaps(whsvr.defaultsNamespace).Get(whsvr.defaultsName)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			warningLogger.Printf("Could not read the defaults ConfigMap %s/%s: %v", whsvr.defaultsNamespace, whsvr.defaultsName, err)
+		}
+		return &builtinDefaults
+	}
+	defaults, err := parseDefaults(configMap.Data[defaultsKey])
+	if err != nil {
+		warningLogger.Printf("Invalid defaults ConfigMap %s/%s, using the builtin defaults: %v", whsvr.defaultsNamespace, whsvr.defaultsName, err)
+		return &builtinDefaults
+	}
+	return defaults
+}
+
+// quotaDefaultsFor returns the quota defaults of a target with the first override matching its namespace applied.
+// A target selecting namespaces by labels is matched on the labels its selector requires.
+func (whsvr *WebhookServer) quotaDefaultsFor(ctx context.Context, defaults *mutationDefaults, quota rlv1beta2.ResourceLimiterQuota) quotaDefaults {
+	if len(defaults.Overrides) == 0 {
+		return defaults.Quota
+	}
+	var namespaceLabels labels.Set
+	switch {
+	case quota.NamespaceName != "" && whsvr.client != nil:
+		namespace := corev1.Namespace{}
+		if err := whsvr.client.Get(ctx, k8stypes.NamespacedName{Name: quota.NamespaceName}, &namespace); err != nil {
+			if !apierrors.IsNotFound(err) {
+				warningLogger.Printf("Could not read the labels of namespace %s: %v", quota.NamespaceName, err)
+			}
+			return defaults.Quota
+		}
+		namespaceLabels = namespace.Labels
+	case quota.NamespaceSelector != nil:
+		namespaceLabels = quota.NamespaceSelector.MatchLabels
+	}
+	for _, override := range defaults.Overrides {
+		selector, err := metav1.LabelSelectorAsSelector(override.NamespaceSelector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(namespaceLabels) {
+			return defaults.Quota.merge(override.Quota)
+		}
+	}
+	return defaults.Quota
+}
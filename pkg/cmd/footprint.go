@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podFootprint computes the effective requests and limits of a pod the way the scheduler does:
+// the larger of the app containers plus the restartable init containers, and of every regular
+// init container plus the restartable ones started before it, with the pod overhead on top
+func podFootprint(template *podTemplate) (corev1.ResourceList, corev1.ResourceList) {
+	requests := footprint(template, func(resources corev1.ResourceRequirements) corev1.ResourceList { return resources.Requests })
+	limits := footprint(template, func(resources corev1.ResourceRequirements) corev1.ResourceList { return resources.Limits })
+	return requests, limits
+}
+
+func footprint(template *podTemplate, list func(corev1.ResourceRequirements) corev1.ResourceList) corev1.ResourceList {
+	total := corev1.ResourceList{}
+	for _, container := range template.spec.Containers {
+		addResources(total, list(container.Resources))
+	}
+
+	sidecars, initMax := corev1.ResourceList{}, corev1.ResourceList{}
+	for i, container := range template.spec.InitContainers {
+		if template.sidecars[i] {
+			addResources(sidecars, list(container.Resources))
+			// A sidecar keeps running next to the init containers started after it
+			maxResources(initMax, sidecars)
+			continue
+		}
+		running := sidecars.DeepCopy()
+		addResources(running, list(container.Resources))
+		maxResources(initMax, running)
+	}
+	addResources(total, sidecars)
+	maxResources(total, initMax)
+	addResources(total, template.spec.Overhead)
+	return total
+}
+
+func addResources(total, list corev1.ResourceList) {
+	for name, value := range list {
+		sum := total[name]
+		sum.Add(value)
+		total[name] = sum
+	}
+}
+
+func maxResources(total, list corev1.ResourceList) {
+	for name, value := range list {
+		if current, ok := total[name]; !ok || value.Cmp(current) > 0 {
+			total[name] = value.DeepCopy()
+		}
+	}
+}
+
+// formatResources prints a resource list in a stable order, e.g. cpu=300m,memory=256Mi
+func formatResources(list corev1.ResourceList) string {
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		value := list[corev1.ResourceName(name)]
+		values = append(values, fmt.Sprintf("%s=%s", name, value.String()))
+	}
+	return strings.Join(values, ",")
+}
@@ -10,15 +10,28 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// quotaResync and defaultsResync are how often the cached resource quotas and defaults ConfigMap are resynced
+const (
+	quotaResync    = 10 * time.Minute
+	defaultsResync = 10 * time.Minute
 )
 
 var (
@@ -28,8 +41,11 @@ var (
 )
 
 var (
-	port                                 int
+	port, metricsPort                    int
 	webhookNamespace, webhookServiceName string
+	denyConflicts                        bool
+	quotaAdmission                       string
+	defaultsConfigMap                    string
 )
 
 func init() {
@@ -46,16 +62,24 @@ func init() {
 	_ = v1.AddToScheme(runtimeScheme)
 	_ = rlv1beta1.AddToScheme(runtimeScheme)
 	_ = rlv1beta2.AddToScheme(runtimeScheme)
+	_ = rlapiv1.AddToScheme(runtimeScheme)
 }
 
 func main() {
 	// init command flags
 	flag.IntVar(&port, "port", 8443, "Webhook server port.")
+	flag.IntVar(&metricsPort, "metrics-port", 9090, "Plain HTTP port serving the webhook metrics, 0 disables it.")
 	flag.StringVar(&webhookServiceName, "service-name", "rl-checker", "Webhook service name.")
+	flag.BoolVar(&denyConflicts, "deny-conflicts", false, "Deny ResourceLimiters taking a namespace from another applied one which outranks them.")
+	flag.StringVar(&defaultsConfigMap, "defaults-configmap", "rl-checker-defaults", "ConfigMap in the webhook namespace holding the defaults the mutating webhook fills into ResourceLimiters.")
+	flag.StringVar(&quotaAdmission, "quota-admission", quotaAdmissionWarn, "What to do with workloads exceeding the namespace resource quota: off, warn or deny.")
 	// flag.StringVar(&sidecarConfigFile, "sidecar-config-file", "/etc/webhook/config/sidecarconfig.yaml", "Sidecar injector configuration file.")
 	// flag.StringVar(&certFile, "tlsCertFile", "/etc/webhook/certs/cert.pem", "x509 Certificate file.")
 	// flag.StringVar(&keyFile, "tlsKeyFile", "/etc/webhook/certs/key.pem", "x509 private key file.")
 	flag.Parse()
+	if quotaAdmission != quotaAdmissionOff && quotaAdmission != quotaAdmissionWarn && quotaAdmission != quotaAdmissionDeny {
+		errorLogger.Fatalf("Invalid --quota-admission %q, expecting off, warn or deny", quotaAdmission)
+	}
 
 	dnsNames := []string{
 		webhookServiceName,
@@ -107,11 +131,48 @@ func main() {
 		errorLogger.Fatalf("Failed to create or update the validating webhook configuration: %v", err)
 	}
 
+	rlClient, err := client.New(config, client.Options{Scheme: runtimeScheme})
+	if err != nil {
+		errorLogger.Fatalf("failed to create resourcelimiter client: %v", err)
+	}
+
 	whsvr := &WebhookServer{
 		server: &http.Server{
 			Addr:      fmt.Sprintf(":%v", port),
 			TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
 		},
+		client:         rlClient,
+		denyConflicts:  denyConflicts,
+		quotaAdmission: quotaAdmission,
+	}
+
+	stopCh := make(chan struct{})
+	// cache the defaults ConfigMap so that edits apply without restarting
+	defaultsFactory := informers.NewSharedInformerFactoryWithOptions(clientset, defaultsResync, informers.WithNamespace(webhookNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", defaultsConfigMap).String()
+		}))
+	whsvr.configMaps = defaultsFactory.Core().V1().ConfigMaps().Lister()
+	whsvr.defaultsNamespace, whsvr.defaultsName = webhookNamespace, defaultsConfigMap
+	defaultsFactory.Start(stopCh)
+	for informer, synced := range defaultsFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			errorLogger.Fatalf("Failed to sync the %v cache", informer)
+		}
+	}
+
+	if quotaAdmission != quotaAdmissionOff {
+		// cache the resource quotas owned by ResourceLimiters for the quota admission
+		factory := informers.NewSharedInformerFactoryWithOptions(clientset, quotaResync, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = constants.OwnerLabel
+		}))
+		whsvr.quotas = factory.Core().V1().ResourceQuotas().Lister()
+		factory.Start(stopCh)
+		for informer, synced := range factory.WaitForCacheSync(stopCh) {
+			if !synced {
+				errorLogger.Fatalf("Failed to sync the %v cache", informer)
+			}
+		}
 	}
 
 	// define http server and server handler
@@ -127,11 +188,20 @@ func main() {
 		}
 	}()
 
+	var metricsServer *http.Server
+	if metricsPort != 0 {
+		metricsServer = serveMetrics(metricsPort)
+	}
+
 	// listening OS shutdown singal
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	<-signalChan
 
 	infoLogger.Printf("Got OS shutdown signal, shutting down webhook server gracefully...")
+	close(stopCh)
 	whsvr.server.Shutdown(context.Background())
+	if metricsServer != nil {
+		metricsServer.Shutdown(context.Background())
+	}
 }
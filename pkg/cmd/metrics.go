@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// denialReasonAnnotation is the audit annotation carrying why a request was denied or warned about
+const denialReasonAnnotation = "denial-reason"
+
+// Reasons of a denied admission request
+const (
+	denialDecodeFailure      = "decode-failure"
+	denialProtectedNamespace = "protected-namespace"
+	denialInvalidTarget      = "invalid-target"
+	denialInvalidQuantity    = "invalid-quantity"
+	denialMissingResources   = "missing-resources"
+	denialConflict           = "conflict"
+	denialLookupFailed       = "lookup-failed"
+	denialPatchFailed        = "patch-failed"
+	denialUnsupportedVersion = "unsupported-version"
+	denialQuotaExceeded      = "quota-exceeded"
+	denialPolicyViolation    = "policy-violation"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	admissionRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rl_checker_admission_requests_total",
+		Help: "Number of admission requests by webhook, kind, operation, verdict and denial reason.",
+	}, []string{"webhook", "kind", "operation", "verdict", "reason"})
+	admissionPatchOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rl_checker_patch_operations_total",
+		Help: "Number of JSON patch operations emitted by the mutating webhook.",
+	}, []string{"kind", "op"})
+	admissionDecodeFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rl_checker_decode_failures_total",
+		Help: "Number of admission reviews or objects which could not be decoded.",
+	}, []string{"webhook"})
+	admissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rl_checker_admission_duration_seconds",
+		Help:    "Latency of the admission requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"webhook", "kind"})
+)
+
+// Webhook names used as metric labels
+const (
+	webhookMutate   = "mutate"
+	webhookValidate = "validate"
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		admissionRequestsTotal,
+		admissionPatchOperationsTotal,
+		admissionDecodeFailuresTotal,
+		admissionDuration,
+	)
+}
+
+// serveMetrics exposes the metrics over plain HTTP, apart from the TLS webhook port
+func serveMetrics(port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: mux,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errorLogger.Fatalf("Failed to listen and serve metrics server: %v", err)
+		}
+	}()
+	return server
+}
+
+// recordAdmission counts an admission request and its response
+func recordAdmission(webhook string, req *admissionv1.AdmissionRequest, response *admissionv1.AdmissionResponse, start time.Time) {
+	kind, operation := "unknown", "unknown"
+	if req != nil {
+		kind, operation = req.Kind.Kind, string(req.Operation)
+	}
+	admissionDuration.WithLabelValues(webhook, kind).Observe(time.Since(start).Seconds())
+
+	verdict, reason := "allowed", ""
+	if response != nil && response.Allowed && len(response.Warnings) != 0 {
+		verdict, reason = "warned", response.AuditAnnotations[denialReasonAnnotation]
+	}
+	if response == nil || !response.Allowed {
+		verdict = "denied"
+		if response != nil {
+			reason = response.AuditAnnotations[denialReasonAnnotation]
+		}
+	}
+	if reason == denialDecodeFailure {
+		admissionDecodeFailuresTotal.WithLabelValues(webhook).Inc()
+	}
+	admissionRequestsTotal.WithLabelValues(webhook, kind, operation, verdict, reason).Inc()
+
+	if response == nil || len(response.Patch) == 0 {
+		return
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(response.Patch, &patch); err != nil {
+		warningLogger.Printf("Could not count patch operations: %v", err)
+		return
+	}
+	for _, op := range patch {
+		admissionPatchOperationsTotal.WithLabelValues(kind, op.Op).Inc()
+	}
+}
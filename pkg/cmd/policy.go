@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+)
+
+// policyResources are the resources a ResourcePolicy bounds
+var policyResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+func isPolicyResource(name corev1.ResourceName) bool {
+	for _, resource := range policyResources {
+		if name == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePolicy checks the policy of a target only bounds cpu and memory, with each min below its max
+// and ratios of at least 1
+func validatePolicy(policy *rlv1beta2.ResourcePolicy, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	supported := make([]string, 0, len(policyResources))
+	for _, name := range policyResources {
+		supported = append(supported, string(name))
+	}
+	for _, scope := range []struct {
+		field  string
+		bounds *rlv1beta2.ResourceBounds
+	}{{"container", policy.Container}, {"pod", policy.Pod}} {
+		if scope.bounds == nil {
+			continue
+		}
+		for _, bound := range []struct {
+			field string
+			list  corev1.ResourceList
+		}{{"min", scope.bounds.Min}, {"max", scope.bounds.Max}} {
+			for _, name := range sortedNames(bound.list) {
+				if !isPolicyResource(name) {
+					allErrs = append(allErrs, field.NotSupported(path.Child(scope.field, bound.field).Key(string(name)), name, supported))
+				}
+				if value := bound.list[name]; value.Sign() < 0 {
+					allErrs = append(allErrs, field.Invalid(path.Child(scope.field, bound.field).Key(string(name)), value.String(), "should not be negative"))
+				}
+			}
+		}
+		for _, name := range policyResources {
+			min, hasMin := scope.bounds.Min[name]
+			max, hasMax := scope.bounds.Max[name]
+			if hasMin && hasMax && min.Cmp(max) > 0 {
+				allErrs = append(allErrs, field.Invalid(path.Child(scope.field, "min").Key(string(name)), min.String(), fmt.Sprintf("exceeds the max %s", max.String())))
+			}
+		}
+	}
+	one := k8sresource.NewQuantity(1, k8sresource.DecimalSI)
+	for _, name := range sortedNames(policy.MaxLimitRequestRatio) {
+		ratio := policy.MaxLimitRequestRatio[name]
+		if !isPolicyResource(name) {
+			allErrs = append(allErrs, field.NotSupported(path.Child("maxLimitRequestRatio").Key(string(name)), name, supported))
+			continue
+		}
+		if ratio.Cmp(*one) < 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("maxLimitRequestRatio").Key(string(name)), ratio.String(), "should be at least 1"))
+		}
+	}
+	return allErrs
+}
+
+// resourcePolicy returns the resource policy the highest ranked applied ResourceLimiter
+// targeting the namespace declares, nil when there is none
+func (whsvr *WebhookServer) resourcePolicy(ctx context.Context, ns string) (*rlv1beta2.ResourcePolicy, error) {
+	quota, err := whsvr.namespaceQuota(ctx, ns, func(quota rlv1beta2.ResourceLimiterQuota) bool { return quota.Policy != nil })
+	if err != nil || quota == nil {
+		return nil, err
+	}
+	return quota.Policy, nil
+}
+
+// boundsViolation returns the first rule the requests and limits break, an empty string when they fit the bounds
+func boundsViolation(bounds *rlv1beta2.ResourceBounds, scope string, requests, limits corev1.ResourceList) string {
+	if bounds == nil {
+		return ""
+	}
+	for _, name := range policyResources {
+		if min, ok := bounds.Min[name]; ok {
+			request, set := requests[name]
+			if !set {
+				return fmt.Sprintf("%s request is not set but the %s min is %s", name, scope, min.String())
+			}
+			if request.Cmp(min) < 0 {
+				return fmt.Sprintf("%s request %s is below the %s min %s", name, request.String(), scope, min.String())
+			}
+		}
+		if max, ok := bounds.Max[name]; ok {
+			limit, set := limits[name]
+			if !set {
+				return fmt.Sprintf("%s limit is not set but the %s max is %s", name, scope, max.String())
+			}
+			if limit.Cmp(max) > 0 {
+				return fmt.Sprintf("%s limit %s exceeds the %s max %s", name, limit.String(), scope, max.String())
+			}
+		}
+	}
+	return ""
+}
+
+// ratioViolation returns the first resource whose limit divided by its request exceeds the max ratio
+func ratioViolation(ratios corev1.ResourceList, resources corev1.ResourceRequirements) string {
+	for _, name := range policyResources {
+		ratio, ok := ratios[name]
+		if !ok {
+			continue
+		}
+		request, limit := resources.Requests[name], resources.Limits[name]
+		if request.IsZero() || limit.IsZero() {
+			return fmt.Sprintf("%s needs a request and a limit for the max limit to request ratio %s", name, ratio.String())
+		}
+		// limit / request > ratio, without dividing
+		if limit.AsDec().Cmp(new(inf.Dec).Mul(ratio.AsDec(), request.AsDec())) > 0 {
+			return fmt.Sprintf("%s limit %s is more than %s times the request %s", name, limit.String(), ratio.String(), request.String())
+		}
+	}
+	return ""
+}
+
+// policyViolation names the container or the pod breaking the policy and the violated rule,
+// an empty string when the workload complies
+func policyViolation(policy *rlv1beta2.ResourcePolicy, template *podTemplate, requests, limits corev1.ResourceList) string {
+	if policy == nil {
+		return ""
+	}
+	check := func(container string, resources corev1.ResourceRequirements) string {
+		if violation := boundsViolation(policy.Container, "container", resources.Requests, resources.Limits); violation != "" {
+			return fmt.Sprintf("%s %s", container, violation)
+		}
+		if violation := ratioViolation(policy.MaxLimitRequestRatio, resources); violation != "" {
+			return fmt.Sprintf("%s %s", container, violation)
+		}
+		return ""
+	}
+	for i, cont := range template.spec.InitContainers {
+		container := "init container " + cont.Name
+		if template.sidecars[i] {
+			container = "sidecar container " + cont.Name
+		}
+		if violation := check(container, cont.Resources); violation != "" {
+			return violation
+		}
+	}
+	for _, cont := range template.spec.Containers {
+		if violation := check("container "+cont.Name, cont.Resources); violation != "" {
+			return violation
+		}
+	}
+	if violation := boundsViolation(policy.Pod, "pod", requests, limits); violation != "" {
+		return "pod " + violation
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/inf.v0"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Quota admission modes
+const (
+	quotaAdmissionOff  = "off"
+	quotaAdmissionWarn = "warn"
+	quotaAdmissionDeny = "deny"
+)
+
+// quotaScoped are the resources a ResourceQuota also accepts without the requests. prefix
+var quotaScoped = map[corev1.ResourceName]bool{
+	corev1.ResourceCPU:              true,
+	corev1.ResourceMemory:           true,
+	corev1.ResourceEphemeralStorage: true,
+}
+
+func quotaName(ns string) string {
+	return fmt.Sprintf("rl-quota-%s", ns)
+}
+
+// scaleQuantity multiplies a quantity by a number of pods without going through int64 milli values
+func scaleQuantity(quantity k8sresource.Quantity, pods int64) k8sresource.Quantity {
+	total := new(inf.Dec).Mul(quantity.AsDec(), inf.NewDec(pods, 0))
+	return *k8sresource.NewDecimalQuantity(*total, quantity.Format)
+}
+
+// quotaUsage is what pods of the template account for in a ResourceQuota
+func quotaUsage(pods int64, requests, limits corev1.ResourceList) corev1.ResourceList {
+	usage := corev1.ResourceList{corev1.ResourcePods: *k8sresource.NewQuantity(pods, k8sresource.DecimalSI)}
+	for name, quantity := range requests {
+		total := scaleQuantity(quantity, pods)
+		usage[corev1.ResourceName("requests."+name)] = total
+		if quotaScoped[name] {
+			usage[name] = total.DeepCopy()
+		}
+	}
+	for name, quantity := range limits {
+		usage[corev1.ResourceName("limits."+name)] = scaleQuantity(quantity, pods)
+	}
+	return usage
+}
+
+// workloadQuotaUsage is the quota the workload needs at the peak of a rollout, its replicas plus the surge
+func workloadQuotaUsage(template *podTemplate) corev1.ResourceList {
+	requests, limits := podFootprint(template)
+	return quotaUsage(template.replicas+template.surge, requests, limits)
+}
+
+// quotaShortfall returns why the workload does not fit in the rl-quota ResourceQuota of its namespace,
+// an empty string when it fits, the namespace has none or its usage depends on the nodes
+func (whsvr *WebhookServer) quotaShortfall(req *admissionv1.AdmissionRequest, template *podTemplate) (string, error) {
+	if whsvr.quotas == nil || template.replicas == 0 {
+		return "", nil
+	}
+	quota, err := whsvr.quotas.ResourceQuotas(req.Namespace).Get(quotaName(req.Namespace))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	need := workloadQuotaUsage(template)
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) != 0 {
+		// The pods of the current object are already part of the quota usage
+		old, err := podTemplateOf(req.Kind.Kind, req.OldObject.Raw)
+		if err != nil {
+			return "", err
+		}
+		requests, limits := podFootprint(old)
+		for name, quantity := range quotaUsage(old.replicas, requests, limits) {
+			if total, ok := need[name]; ok {
+				total.Sub(quantity)
+				need[name] = total
+			}
+		}
+	}
+
+	names := make([]string, 0, len(need))
+	for name := range need {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	shortfalls := []string{}
+	for _, name := range names {
+		hard, ok := quota.Spec.Hard[corev1.ResourceName(name)]
+		if !ok {
+			continue
+		}
+		remaining := hard.DeepCopy()
+		remaining.Sub(quota.Status.Used[corev1.ResourceName(name)])
+		required := need[corev1.ResourceName(name)]
+		if required.Cmp(remaining) > 0 {
+			shortfalls = append(shortfalls, fmt.Sprintf("%s needs %s but %s remains", name, required.String(), remaining.String()))
+		}
+	}
+	if len(shortfalls) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("%s %s does not fit in resource quota %s: %s", strings.ToLower(req.Kind.Kind), template.name, quota.Name, strings.Join(shortfalls, ", ")), nil
+}
+
+// admitQuota denies or warns about a workload exceeding its namespace quota depending on the quota admission mode,
+// it returns nil when the workload may go on
+func (whsvr *WebhookServer) admitQuota(req *admissionv1.AdmissionRequest, template *podTemplate) *admissionv1.AdmissionResponse {
+	if whsvr.quotaAdmission == quotaAdmissionOff || whsvr.quotaAdmission == "" {
+		return nil
+	}
+	shortfall, err := whsvr.quotaShortfall(req, template)
+	if err != nil {
+		warningLogger.Printf("Could not check %s %s against its resource quota: %v", req.Kind.Kind, req.Name, err)
+		return nil
+	}
+	if shortfall == "" {
+		return nil
+	}
+	if whsvr.quotaAdmission == quotaAdmissionDeny {
+		return denied(denialQuotaExceeded, shortfall)
+	}
+	warningLogger.Print(shortfall)
+	return &admissionv1.AdmissionResponse{
+		Allowed:          true,
+		Warnings:         []string{shortfall},
+		AuditAnnotations: map[string]string{denialReasonAnnotation: denialQuotaExceeded},
+		Result: &metav1.Status{
+			Message: shortfall,
+		},
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/inf.v0"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
+	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/capacity"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+// admissionErrors collects every problem of a request, the denial reason is the one of the first problem
+type admissionErrors struct {
+	errs   field.ErrorList
+	reason string
+}
+
+func (a *admissionErrors) add(reason string, errs ...*field.Error) {
+	if len(errs) == 0 {
+		return
+	}
+	if len(a.errs) == 0 {
+		a.reason = reason
+	}
+	a.errs = append(a.errs, errs...)
+}
+
+// deny returns nil when nothing was collected, and otherwise an Invalid status listing every cause
+func (a *admissionErrors) deny(kind schema.GroupKind, name string) *admissionv1.AdmissionResponse {
+	if len(a.errs) == 0 {
+		return nil
+	}
+	status := apierrors.NewInvalid(kind, name, a.errs).Status()
+	response := denied(a.reason, status.Message)
+	response.Result = &status
+	return response
+}
+
+// validateQuantity parses a quantity of a ResourceLimiter, the error carries the path of the field
+func validateQuantity(value string, path *field.Path) *field.Error {
+	if _, err := k8sresource.ParseQuantity(value); err != nil {
+		return field.Invalid(path, value, err.Error())
+	}
+	return nil
+}
+
+// validateRequestLimit checks the request and the limit parse and that the request does not exceed the limit,
+// either may be empty
+func validateRequestLimit(request, limit string, requestPath, limitPath *field.Path, errs *admissionErrors) {
+	var parsed []k8sresource.Quantity
+	for _, value := range []struct {
+		value string
+		path  *field.Path
+	}{{request, requestPath}, {limit, limitPath}} {
+		if value.value == "" {
+			continue
+		}
+		quantity, err := k8sresource.ParseQuantity(value.value)
+		if err != nil {
+			errs.add(denialInvalidQuantity, field.Invalid(value.path, value.value, err.Error()))
+			continue
+		}
+		parsed = append(parsed, quantity)
+	}
+	if len(parsed) == 2 && parsed[0].Cmp(parsed[1]) > 0 {
+		errs.add(denialInvalidQuantity, field.Invalid(requestPath, request, fmt.Sprintf("must be less than or equal to %s %s", limitPath.String(), limit)))
+	}
+}
+
+// validateShorthands is validateRequestLimit for the cpu and memory shorthands of a target, which may also be
+// percentages of the allocatable capacity of the nodes. A pair is either two quantities or two percentages,
+// a percentage is never compared with a quantity.
+func validateShorthands(request, limit string, requestPath, limitPath *field.Path, errs *admissionErrors) {
+	if !capacity.IsPercent(request) && !capacity.IsPercent(limit) {
+		validateRequestLimit(request, limit, requestPath, limitPath, errs)
+		return
+	}
+	if request != "" && limit != "" && capacity.IsPercent(request) != capacity.IsPercent(limit) {
+		errs.add(denialInvalidQuantity, field.Invalid(requestPath, request,
+			fmt.Sprintf("should be of the same kind as %s %s, both quantities or both percentages", limitPath.String(), limit)))
+	}
+	var percents []*inf.Dec
+	for _, value := range []struct {
+		value string
+		path  *field.Path
+	}{{request, requestPath}, {limit, limitPath}} {
+		switch {
+		case value.value == "":
+		case capacity.IsPercent(value.value):
+			percent, err := capacity.ParsePercent(value.value)
+			if err != nil {
+				errs.add(denialInvalidQuantity, field.Invalid(value.path, value.value, err.Error()))
+				continue
+			}
+			percents = append(percents, percent)
+		default:
+			if err := validateQuantity(value.value, value.path); err != nil {
+				errs.add(denialInvalidQuantity, err)
+			}
+		}
+	}
+	if len(percents) == 2 && percents[0].Cmp(percents[1]) > 0 {
+		errs.add(denialInvalidQuantity, field.Invalid(requestPath, request, fmt.Sprintf("must be less than or equal to %s %s", limitPath.String(), limit)))
+	}
+}
+
+func isProtectedNamespace(ns string) bool {
+	return ns == string(constants.IgnoreKubeSystem) || ns == string(constants.IgnoreKubePublic)
+}
+
+// validateNamespaceName checks a target names a namespace once and not one of the preset namespaces
+func validateNamespaceName(ns string, path *field.Path, seen map[string]bool, errs *admissionErrors) {
+	if isProtectedNamespace(ns) {
+		errs.add(denialProtectedNamespace, field.Forbidden(path, fmt.Sprintf("should avoid limit on the preset namespace %s", ns)))
+	}
+	if seen[ns] {
+		errs.add(denialInvalidTarget, field.Duplicate(path, ns))
+	}
+	seen[ns] = true
+}
+
+// validateResourceLimiterV1beta1 returns every problem of a v1beta1 ResourceLimiter
+func validateResourceLimiterV1beta1(rl *rlv1beta1.ResourceLimiter) *admissionErrors {
+	errs := &admissionErrors{}
+	seen := map[string]bool{}
+	for i, ns := range rl.Spec.Targets {
+		path := field.NewPath("spec", "targets").Index(i)
+		if ns == "" {
+			errs.add(denialInvalidTarget, field.Required(path, "the namespace name should not be empty"))
+			continue
+		}
+		validateNamespaceName(string(ns), path, seen, errs)
+	}
+
+	typesPath := field.NewPath("spec", "types")
+	pairs := [][2]rlv1beta1.ResourceLimiterType{
+		{constants.RetrainTypeRequestsCpu, constants.RetrainTypeLimitsCpu},
+		{constants.RetrainTypeRequestsMemory, constants.RetrainTypeLimitsMemory},
+	}
+	for _, pair := range pairs {
+		validateRequestLimit(rl.Spec.Types[pair[0]], rl.Spec.Types[pair[1]], typesPath.Key(string(pair[0])), typesPath.Key(string(pair[1])), errs)
+	}
+	types := make([]string, 0, len(rl.Spec.Types))
+	for t := range rl.Spec.Types {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		switch rlv1beta1.ResourceLimiterType(t) {
+		case constants.RetrainTypeRequestsCpu, constants.RetrainTypeLimitsCpu, constants.RetrainTypeRequestsMemory, constants.RetrainTypeLimitsMemory:
+			// checked in pairs above
+		default:
+			if err := validateQuantity(rl.Spec.Types[rlv1beta1.ResourceLimiterType(t)], typesPath.Key(t)); err != nil {
+				errs.add(denialInvalidQuantity, err)
+			}
+		}
+	}
+	return errs
+}
+
+// shorthandPair is the request and the limit of cpu or memory of a target, with the paths they are read from
+type shorthandPair struct {
+	request, limit         string
+	requestPath, limitPath *field.Path
+}
+
+// hubShorthands returns the cpu and memory pairs of a target of the hub
+func hubShorthands(quota rlv1beta2.ResourceLimiterQuota, target *field.Path) []shorthandPair {
+	return []shorthandPair{
+		{quota.CpuRequest, quota.CpuLimit, target.Child("cpu_requests"), target.Child("cpu_limits")},
+		{quota.MemRequest, quota.MemLimit, target.Child("mem_requests"), target.Child("mem_limits")},
+	}
+}
+
+// v1Shorthand resolves a shorthand of a v1 target from its percentage when set and from its quantity otherwise,
+// as the conversion into the hub does
+func v1Shorthand(quantity *k8sresource.Quantity, percentage string, target *field.Path, name string) (string, *field.Path) {
+	if percentage != "" {
+		return percentage, target.Child("percentages", name)
+	}
+	if quantity == nil {
+		return "", target.Child(name)
+	}
+	return quantity.String(), target.Child(name)
+}
+
+// v1Shorthands returns the cpu and memory pairs of a v1 target, where the percentages are kept apart from the quantities
+func v1Shorthands(quota rlapiv1.ResourceLimiterQuota, target *field.Path) []shorthandPair {
+	percentages := rlapiv1.ResourcePercentages{}
+	if quota.Percentages != nil {
+		percentages = *quota.Percentages
+	}
+	var pairs []shorthandPair
+	for _, pair := range []struct {
+		request, limit               *k8sresource.Quantity
+		requestPercent, limitPercent string
+		requestName, limitName       string
+	}{
+		{quota.CpuRequest, quota.CpuLimit, percentages.CpuRequest, percentages.CpuLimit, "cpu_requests", "cpu_limits"},
+		{quota.MemRequest, quota.MemLimit, percentages.MemRequest, percentages.MemLimit, "mem_requests", "mem_limits"},
+	} {
+		request, requestPath := v1Shorthand(pair.request, pair.requestPercent, target, pair.requestName)
+		limit, limitPath := v1Shorthand(pair.limit, pair.limitPercent, target, pair.limitName)
+		pairs = append(pairs, shorthandPair{request, limit, requestPath, limitPath})
+	}
+	return pairs
+}
+
+// validateResourceLimiterV1 returns every problem of a v1 ResourceLimiter, the hub is the same ResourceLimiter converted.
+// The shorthands are paired from the v1 layout, and a shorthand set both as a quantity and as a percentage is denied
+// as the conversion would drop the quantity.
+func validateResourceLimiterV1(rl *rlapiv1.ResourceLimiter, hub *rlv1beta2.ResourceLimiter) *admissionErrors {
+	errs := validateResourceLimiter(hub, func(i int, target *field.Path) []shorthandPair {
+		return v1Shorthands(rl.Spec.Quotas[i], target)
+	})
+	for i, quota := range rl.Spec.Quotas {
+		if quota.Percentages == nil {
+			continue
+		}
+		target := field.NewPath("spec", "targets").Index(i)
+		for _, shorthand := range []struct {
+			quantity   *k8sresource.Quantity
+			percentage string
+			name       string
+		}{
+			{quota.CpuRequest, quota.Percentages.CpuRequest, "cpu_requests"},
+			{quota.CpuLimit, quota.Percentages.CpuLimit, "cpu_limits"},
+			{quota.MemRequest, quota.Percentages.MemRequest, "mem_requests"},
+			{quota.MemLimit, quota.Percentages.MemLimit, "mem_limits"},
+		} {
+			if shorthand.quantity != nil && shorthand.percentage != "" {
+				errs.add(denialInvalidQuantity, field.Invalid(target.Child(shorthand.name), shorthand.quantity.String(),
+					fmt.Sprintf("should not be set with %s", target.Child("percentages", shorthand.name).String())))
+			}
+		}
+	}
+	return errs
+}
+
+// validateResourceLimiterV1beta2 returns every problem of a ResourceLimiter decoded into the hub
+func validateResourceLimiterV1beta2(rl *rlv1beta2.ResourceLimiter) *admissionErrors {
+	return validateResourceLimiter(rl, func(i int, target *field.Path) []shorthandPair {
+		return hubShorthands(rl.Spec.Quotas[i], target)
+	})
+}
+
+// validateResourceLimiter returns every problem of a ResourceLimiter decoded into the hub,
+// shorthands returns the cpu and memory pairs of a target in the layout of the version of the request
+func validateResourceLimiter(rl *rlv1beta2.ResourceLimiter, shorthands func(i int, target *field.Path) []shorthandPair) *admissionErrors {
+	errs := &admissionErrors{}
+	seen := map[string]bool{}
+	for i, quota := range rl.Spec.Quotas {
+		target := field.NewPath("spec", "targets").Index(i)
+		if quota.NamespaceName == "" && quota.NamespaceSelector == nil {
+			errs.add(denialInvalidTarget, field.Required(target.Child("name"), "either name or namespaceSelector should be set"))
+		}
+		if quota.NamespaceName != "" {
+			validateNamespaceName(quota.NamespaceName, target.Child("name"), seen, errs)
+		}
+		if quota.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(quota.NamespaceSelector); err != nil {
+				errs.add(denialInvalidTarget, field.Invalid(target.Child("namespaceSelector"), quota.NamespaceSelector.String(), err.Error()))
+			}
+		}
+
+		// The shorthands are optional once the resource is set in hard
+		for _, pair := range shorthands(i, target) {
+			validateShorthands(pair.request, pair.limit, pair.requestPath, pair.limitPath, errs)
+		}
+
+		hard := target.Child("hard")
+		for _, name := range sortedNames(quota.Hard) {
+			if msgs := validation.IsQualifiedName(string(name)); len(msgs) != 0 {
+				for _, msg := range msgs {
+					errs.add(denialInvalidTarget, field.Invalid(hard.Key(string(name)), string(name), msg))
+				}
+			}
+			if value := quota.Hard[name]; value.Sign() < 0 {
+				errs.add(denialInvalidQuantity, field.Invalid(hard.Key(string(name)), value.String(), "should not be negative"))
+			}
+		}
+		for _, resource := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage} {
+			request, hasRequest := quota.Hard[corev1.ResourceName("requests."+resource)]
+			limit, hasLimit := quota.Hard[corev1.ResourceName("limits."+resource)]
+			if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+				errs.add(denialInvalidQuantity, field.Invalid(hard.Key("requests."+string(resource)), request.String(),
+					fmt.Sprintf("must be less than or equal to %s %s", hard.Key("limits."+string(resource)).String(), limit.String())))
+			}
+		}
+
+		if quota.LimitRange != nil {
+			for j, item := range quota.LimitRange.Limits {
+				switch item.Type {
+				case corev1.LimitTypeContainer, corev1.LimitTypePod, corev1.LimitTypePersistentVolumeClaim:
+				default:
+					errs.add(denialInvalidTarget, field.NotSupported(target.Child("limitRange", "limits").Index(j).Child("type"), item.Type,
+						[]string{string(corev1.LimitTypeContainer), string(corev1.LimitTypePod), string(corev1.LimitTypePersistentVolumeClaim)}))
+				}
+			}
+		}
+		if quota.Policy != nil {
+			errs.add(denialInvalidTarget, validatePolicy(quota.Policy, target.Child("policy"))...)
+		}
+	}
+	if rl.Spec.Budget != nil {
+		validateBudget(rl.Spec.Budget, field.NewPath("spec", "budget"), errs)
+	}
+	if rl.Spec.NodeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(rl.Spec.NodeSelector); err != nil {
+			errs.add(denialInvalidTarget, field.Invalid(field.NewPath("spec", "nodeSelector"), rl.Spec.NodeSelector.String(), err.Error()))
+		}
+	}
+	return errs
+}
+
+// validateBudget checks the budget sets valid amounts and a split it knows how to divide
+func validateBudget(budget *rlv1beta2.ResourceBudget, path *field.Path, errs *admissionErrors) {
+	hard := path.Child("hard")
+	if len(budget.Hard) == 0 {
+		errs.add(denialInvalidTarget, field.Required(hard, "the budget should set at least one resource"))
+	}
+	for _, name := range sortedNames(budget.Hard) {
+		for _, msg := range validation.IsQualifiedName(string(name)) {
+			errs.add(denialInvalidTarget, field.Invalid(hard.Key(string(name)), string(name), msg))
+		}
+		if value := budget.Hard[name]; value.Sign() < 0 {
+			errs.add(denialInvalidQuantity, field.Invalid(hard.Key(string(name)), value.String(), "should not be negative"))
+		}
+	}
+	switch budget.Split {
+	case "", rlv1beta2.BudgetSplitEqual, rlv1beta2.BudgetSplitUsage:
+	case rlv1beta2.BudgetSplitWeighted:
+		if budget.WeightKey == "" {
+			errs.add(denialInvalidTarget, field.Required(path.Child("weightKey"), "the Weighted split reads the weights from this label or annotation"))
+		}
+	default:
+		errs.add(denialInvalidTarget, field.NotSupported(path.Child("split"), budget.Split,
+			[]string{string(rlv1beta2.BudgetSplitEqual), string(rlv1beta2.BudgetSplitWeighted), string(rlv1beta2.BudgetSplitUsage)}))
+	}
+	if budget.WeightKey != "" {
+		for _, msg := range validation.IsQualifiedName(budget.WeightKey) {
+			errs.add(denialInvalidTarget, field.Invalid(path.Child("weightKey"), budget.WeightKey, msg))
+		}
+	}
+	if budget.Rebalance != nil {
+		validateRebalance(budget.Rebalance, budget.Hard, path.Child("rebalance"), errs)
+	}
+}
+
+// validateRebalance checks the watermarks leave room between them and the bounds only name resources of the budget
+func validateRebalance(rebalance *rlv1beta2.BudgetRebalance, budget corev1.ResourceList, path *field.Path, errs *admissionErrors) {
+	if rebalance.Interval.Duration < 0 {
+		errs.add(denialInvalidTarget, field.Invalid(path.Child("interval"), rebalance.Interval.Duration.String(), "should not be negative"))
+	}
+	high, low := rebalance.HighWatermark, rebalance.LowWatermark
+	for _, watermark := range []struct {
+		field string
+		value *int32
+		unset int32
+	}{{"highWatermark", &high, constants.DefaultHighWatermark}, {"lowWatermark", &low, constants.DefaultLowWatermark}} {
+		if *watermark.value < 0 || *watermark.value > 100 {
+			errs.add(denialInvalidTarget, field.Invalid(path.Child(watermark.field), *watermark.value,
+				fmt.Sprintf("should be a percent between 1 and 100, or 0 for the default %d", watermark.unset)))
+		}
+		if *watermark.value == 0 {
+			*watermark.value = watermark.unset
+		}
+	}
+	// The watermarks left unset are compared by their defaults, as the controller rebalances with them
+	if low >= high {
+		errs.add(denialInvalidTarget, field.Invalid(path.Child("lowWatermark"), rebalance.LowWatermark, fmt.Sprintf("should be below the highWatermark %d", high)))
+	}
+	for _, bound := range []struct {
+		field string
+		list  corev1.ResourceList
+	}{{"floor", rebalance.Floor}, {"ceiling", rebalance.Ceiling}, {"maxStep", rebalance.MaxStep}} {
+		for _, name := range sortedNames(bound.list) {
+			if _, ok := budget[name]; !ok {
+				errs.add(denialInvalidTarget, field.Invalid(path.Child(bound.field).Key(string(name)), string(name), "should be a resource of the budget"))
+			}
+			if value := bound.list[name]; value.Sign() < 0 {
+				errs.add(denialInvalidQuantity, field.Invalid(path.Child(bound.field).Key(string(name)), value.String(), "should not be negative"))
+			} else if bound.field == "maxStep" && value.IsZero() {
+				errs.add(denialInvalidQuantity, field.Invalid(path.Child(bound.field).Key(string(name)), value.String(), "should be positive"))
+			}
+		}
+	}
+	for _, name := range sortedNames(rebalance.Floor) {
+		floor := rebalance.Floor[name]
+		if ceiling, ok := rebalance.Ceiling[name]; ok && floor.Cmp(ceiling) > 0 {
+			errs.add(denialInvalidQuantity, field.Invalid(path.Child("floor").Key(string(name)), floor.String(), fmt.Sprintf("exceeds the ceiling %s", ceiling.String())))
+		}
+	}
+}
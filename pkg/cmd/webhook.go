@@ -1,29 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"reflect"
+	"sort"
+	"time"
 
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/capacity"
+	"github.com/chenliu1993/resourcelimiter/pkg/conflict"
 	"github.com/chenliu1993/resourcelimiter/pkg/constants"
 	admissionv1 "k8s.io/api/admission/v1"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var (
 	runtimeScheme = runtime.NewScheme()
 	codecs        = serializer.NewCodecFactory(runtimeScheme)
 	deserializer  = codecs.UniversalDeserializer()
-	resFormErr    error
 )
 
 // const (
@@ -33,6 +39,16 @@ var (
 
 type WebhookServer struct {
 	server *http.Server
+	// client reads the existing ResourceLimiters and namespaces when denyConflicts is set
+	client        client.Client
+	denyConflicts bool
+	// quotas caches the ResourceQuotas owned by ResourceLimiters, quotaAdmission tells whether
+	// workloads exceeding them are denied, warned about or let through
+	quotas         corelisters.ResourceQuotaLister
+	quotaAdmission string
+	// configMaps caches the ConfigMap defaultsNamespace/defaultsName holding the mutation defaults
+	configMaps                      corelisters.ConfigMapLister
+	defaultsNamespace, defaultsName string
 }
 
 // Webhook Server parameters
@@ -48,148 +64,243 @@ type patchOperation struct {
 	Value interface{} `json:"value,omitempty"`
 }
 
-// Check whether the target resoured need to be mutated
-func mutationRequiredV1beta1(rl *rlv1beta1.ResourceLimiter) (bool, bool) {
-	var requiredTypes, requiredTargets bool
-	if len(rl.Spec.Targets) == 0 {
-		requiredTargets = true
-	}
-
-	if len(rl.Spec.Types) == 0 {
-		requiredTypes = true
+// denied returns a response rejecting the request, the reason is kept in the audit annotations
+func denied(reason, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Message: message,
+		},
+		AuditAnnotations: map[string]string{
+			denialReasonAnnotation: reason,
+		},
 	}
+}
 
-	if _, ok := rl.Spec.Types[constants.RetrainTypeLimitsCpu]; !ok {
-		requiredTypes = true
+// compareQuantities compares two quantities or two percentages, unset or malformed ones, and a percentage
+// against a quantity, compare equal to anything
+func compareQuantities(a, b string) int {
+	if a == "" || b == "" || capacity.IsPercent(a) != capacity.IsPercent(b) {
+		return 0
 	}
-	if _, ok := rl.Spec.Types[constants.RetrainTypeLimitsMemory]; !ok {
-		requiredTypes = true
+	if capacity.IsPercent(a) {
+		pa, err := capacity.ParsePercent(a)
+		if err != nil {
+			return 0
+		}
+		pb, err := capacity.ParsePercent(b)
+		if err != nil {
+			return 0
+		}
+		return pa.Cmp(pb)
 	}
-	if _, ok := rl.Spec.Types[constants.RetrainTypeRequestsCpu]; !ok {
-		requiredTypes = true
+	qa, err := k8sresource.ParseQuantity(a)
+	if err != nil {
+		return 0
 	}
-	if _, ok := rl.Spec.Types[constants.RetrainTypeRequestsMemory]; !ok {
-		requiredTypes = true
+	qb, err := k8sresource.ParseQuantity(b)
+	if err != nil {
+		return 0
 	}
-
-	infoLogger.Printf("Mutation policy for v1beta1/%v required:%v", rl.Name, requiredTypes || requiredTargets)
-	return requiredTypes, requiredTargets
+	return qa.Cmp(qb)
 }
 
-func updateResourceLimiterTypesV1beta1(added map[rlv1beta1.ResourceLimiterType]string) (patch []patchOperation) {
-	patch = append(patch, patchOperation{
-		Op:    "add",
-		Path:  "/spec/types",
-		Value: added,
-	})
-	return patch
+// fillPair fills a missing request or limit from the defaults. A defaulted request never exceeds the limit
+// and a defaulted limit is never below the request. When the other side is a percentage of the allocatable
+// capacity, a default quantity would make a mixed pair, so the missing side is only filled with a percentage.
+func fillPair(request, limit, defaultRequest, defaultLimit string) (string, string) {
+	filledRequest, filledLimit := request, limit
+	if filledRequest == "" && (limit == "" || capacity.IsPercent(defaultRequest) == capacity.IsPercent(limit)) {
+		filledRequest = defaultRequest
+		if compareQuantities(filledRequest, limit) > 0 {
+			filledRequest = limit
+		}
+	}
+	if filledLimit == "" && (filledRequest == "" || capacity.IsPercent(defaultLimit) == capacity.IsPercent(filledRequest)) {
+		filledLimit = defaultLimit
+		if compareQuantities(filledLimit, filledRequest) < 0 {
+			filledLimit = filledRequest
+		}
+	}
+	return filledRequest, filledLimit
 }
 
-func updateResourceLimiterTargetsV1beta1(target []rlv1beta1.ResourceLimiterNamespace, added []rlv1beta1.ResourceLimiterNamespace) (patch []patchOperation) {
-	for _, item := range added {
-		if len(target) == 0 {
-			target = []rlv1beta1.ResourceLimiterNamespace{}
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/spec/targets",
-				Value: []rlv1beta1.ResourceLimiterNamespace{
-					item,
-				},
-			})
-		} else {
+// createPatchV1beta1 adds the types a v1beta1 ResourceLimiter misses and the default target when it has none.
+// The types apply to every target, so the overrides by namespace labels do not apply to v1beta1.
+func (whsvr *WebhookServer) createPatchV1beta1(rl *rlv1beta1.ResourceLimiter) []patchOperation {
+	defaults := whsvr.mutationDefaults()
+	types := map[rlv1beta1.ResourceLimiterType]string{}
+	for _, pair := range []struct {
+		request, limit               rlv1beta1.ResourceLimiterType
+		defaultRequest, defaultLimit string
+	}{
+		{constants.RetrainTypeRequestsCpu, constants.RetrainTypeLimitsCpu, defaults.Quota.CpuRequest, defaults.Quota.CpuLimit},
+		{constants.RetrainTypeRequestsMemory, constants.RetrainTypeLimitsMemory, defaults.Quota.MemRequest, defaults.Quota.MemLimit},
+	} {
+		request, limit := fillPair(rl.Spec.Types[pair.request], rl.Spec.Types[pair.limit], pair.defaultRequest, pair.defaultLimit)
+		if _, ok := rl.Spec.Types[pair.request]; !ok && request != "" {
+			types[pair.request] = request
+		}
+		if _, ok := rl.Spec.Types[pair.limit]; !ok && limit != "" {
+			types[pair.limit] = limit
+		}
+	}
+
+	var patch []patchOperation
+	if len(rl.Spec.Types) == 0 && len(types) != 0 {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/types", Value: types})
+	} else {
+		names := make([]string, 0, len(types))
+		for name := range types {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
 			patch = append(patch, patchOperation{
 				Op:    "add",
-				Path:  "/spec/targets/-",
-				Value: item,
+				Path:  "/spec/types/" + escapePointer(corev1.ResourceName(name)),
+				Value: types[rlv1beta1.ResourceLimiterType(name)],
 			})
 		}
 	}
+	if len(rl.Spec.Targets) == 0 {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/spec/targets",
+			Value: []rlv1beta1.ResourceLimiterNamespace{rlv1beta1.ResourceLimiterNamespace(defaults.Namespace)},
+		})
+	}
+	infoLogger.Printf("Mutation policy for v1beta1/%v required:%v", rl.Name, len(patch) != 0)
 	return patch
 }
 
-// create mutation patch for resoures
-func createPatchV1beta1(rl *rlv1beta1.ResourceLimiter, desired *rlv1beta1.ResourceLimiter) ([]byte, error) {
-	var patch []patchOperation
-
-	requiredTypes, requiredTargets := mutationRequiredV1beta1(rl)
-	if requiredTypes {
-		patch = append(patch, updateResourceLimiterTypesV1beta1(desired.Spec.Types)...)
+// fillQuota returns the quota with the missing cpu and memory shorthands set from the defaults,
+// a shorthand is not missing if the same resource is set in hard
+func fillQuota(quota rlv1beta2.ResourceLimiterQuota, defaults quotaDefaults) rlv1beta2.ResourceLimiterQuota {
+	current := func(value string, name corev1.ResourceName) string {
+		if hard, ok := quota.Hard[name]; ok && value == "" {
+			return hard.String()
+		}
+		return value
+	}
+	filled := quota
+	cpuRequest, cpuLimit := fillPair(current(quota.CpuRequest, corev1.ResourceRequestsCPU), current(quota.CpuLimit, corev1.ResourceLimitsCPU),
+		defaults.CpuRequest, defaults.CpuLimit)
+	memRequest, memLimit := fillPair(current(quota.MemRequest, corev1.ResourceRequestsMemory), current(quota.MemLimit, corev1.ResourceLimitsMemory),
+		defaults.MemRequest, defaults.MemLimit)
+	for _, field := range []struct {
+		value        *string
+		filled, hard string
+		name         corev1.ResourceName
+	}{
+		{&filled.CpuRequest, cpuRequest, quota.CpuRequest, corev1.ResourceRequestsCPU},
+		{&filled.CpuLimit, cpuLimit, quota.CpuLimit, corev1.ResourceLimitsCPU},
+		{&filled.MemRequest, memRequest, quota.MemRequest, corev1.ResourceRequestsMemory},
+		{&filled.MemLimit, memLimit, quota.MemLimit, corev1.ResourceLimitsMemory},
+	} {
+		if _, ok := quota.Hard[field.name]; !ok && field.hard == "" {
+			*field.value = field.filled
+		}
 	}
+	return filled
+}
 
-	if requiredTargets {
-		patch = append(patch, updateResourceLimiterTargetsV1beta1(rl.Spec.Targets, desired.Spec.Targets)...)
+// targetFields is the json object of every target, as written in the ResourceLimiter of the version the targets belong to
+func targetFields(targets interface{}) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return nil, err
 	}
-
-	return json.Marshal(patch)
+	fields := []map[string]interface{}{}
+	return fields, json.Unmarshal(data, &fields)
 }
 
-// Check whether the target resoured need to be mutated
-func mutationRequiredV1beta2(rl *rlv1beta2.ResourceLimiter) map[string]bool {
-
-	if len(rl.Spec.Quotas) == 0 {
-		return map[string]bool{
-			"default": true,
+// diffQuotas returns the add and replace operations turning the original targets into the desired ones,
+// field by field so that each entry is patched in place instead of appended again.
+// Both are targets of the version of the request, so that the operations match its json layout.
+func diffQuotas(original, desired interface{}) ([]patchOperation, error) {
+	from, err := targetFields(original)
+	if err != nil {
+		return nil, err
+	}
+	to, err := targetFields(desired)
+	if err != nil {
+		return nil, err
+	}
+	if len(from) == 0 {
+		if len(to) == 0 {
+			return nil, nil
 		}
+		return []patchOperation{{Op: "add", Path: "/spec/targets", Value: desired}}, nil
 	}
 
-	requiredQuotas := map[string]bool{}
-	for _, v := range rl.Spec.Quotas {
-		if v.CpuLimit == "" || v.CpuRequest == "" || v.MemLimit == "" || v.MemRequest == "" {
-			// This ns should be mutated
-			requiredQuotas[v.NamespaceName] = true
+	var patch []patchOperation
+	for i := range from {
+		names := make([]string, 0, len(to[i]))
+		for name := range to[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := fmt.Sprintf("/spec/targets/%d/%s", i, escapePointer(corev1.ResourceName(name)))
+			value, ok := from[i][name]
+			switch {
+			case !ok:
+				patch = append(patch, patchOperation{Op: "add", Path: path, Value: to[i][name]})
+			case !reflect.DeepEqual(value, to[i][name]):
+				patch = append(patch, patchOperation{Op: "replace", Path: path, Value: to[i][name]})
+			}
 		}
 	}
+	return patch, nil
+}
 
-	infoLogger.Printf("Mutation policy for v1beta2/%v required:%v", rl.Name, requiredQuotas)
-	return requiredQuotas
+// defaultQuotas returns the targets with the shorthands each one misses filled from the defaults,
+// and the default target when there is none
+func (whsvr *WebhookServer) defaultQuotas(ctx context.Context, quotas []rlv1beta2.ResourceLimiterQuota) []rlv1beta2.ResourceLimiterQuota {
+	defaults := whsvr.mutationDefaults()
+	desired := make([]rlv1beta2.ResourceLimiterQuota, 0, len(quotas))
+	for _, quota := range quotas {
+		desired = append(desired, fillQuota(quota, whsvr.quotaDefaultsFor(ctx, defaults, quota)))
+	}
+	if len(desired) == 0 {
+		quota := rlv1beta2.ResourceLimiterQuota{NamespaceName: defaults.Namespace}
+		desired = append(desired, fillQuota(quota, whsvr.quotaDefaultsFor(ctx, defaults, quota)))
+	}
+	return desired
 }
 
-func updateResourceLimiterQuotasV1beta2(target, added []rlv1beta2.ResourceLimiterQuota) (patch []patchOperation) {
-	for _, item := range added {
-		if len(target) == 0 {
-			target = []rlv1beta2.ResourceLimiterQuota{}
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/spec/targets",
-				Value: []rlv1beta2.ResourceLimiterQuota{
-					item,
-				},
-			})
-		} else {
-			patch = append(patch, patchOperation{
-				Op:    "add",
-				Path:  "/spec/targets/-",
-				Value: item,
-			})
-		}
+// createPatchV1beta2 defaults the shorthands each target misses, and adds the default target when there is none,
+// the patch is the difference between the original and the defaulted targets
+func (whsvr *WebhookServer) createPatchV1beta2(ctx context.Context, rl *rlv1beta2.ResourceLimiter) ([]patchOperation, error) {
+	patch, err := diffQuotas(rl.Spec.Quotas, whsvr.defaultQuotas(ctx, rl.Spec.Quotas))
+	if err != nil {
+		return nil, err
 	}
-	return patch
+	infoLogger.Printf("Mutation policy for v1beta2/%v required:%v", rl.Name, len(patch) != 0)
+	return patch, nil
 }
 
-// create mutation patch for resoures
-func createPatchV1beta2(rl *rlv1beta2.ResourceLimiter, desired *rlv1beta2.ResourceLimiter) ([]byte, error) {
-	var patch []patchOperation
+// createPatchV1 defaults the targets of a v1 ResourceLimiter on the hub, where each shorthand is read from
+// its percentage when set and from its quantity otherwise. The defaulted targets are converted back to v1
+// before the diff, so that the patch writes quantities and percentages where v1 keeps them.
+func (whsvr *WebhookServer) createPatchV1(ctx context.Context, rl *rlapiv1.ResourceLimiter) ([]patchOperation, error) {
+	hub := rlv1beta2.ResourceLimiter{}
+	if err := rl.ConvertTo(&hub); err != nil {
+		return nil, err
+	}
+	hub.Spec.Quotas = whsvr.defaultQuotas(ctx, hub.Spec.Quotas)
+	desired := rlapiv1.ResourceLimiter{}
+	if err := desired.ConvertFrom(&hub); err != nil {
+		return nil, err
+	}
 
-	rl.Spec.Quotas = []rlv1beta2.ResourceLimiterQuota{}
-	requiredQuotas := mutationRequiredV1beta2(rl)
-	// TODO: better  find
-	for k, v := range requiredQuotas {
-		for _, quota := range desired.Spec.Quotas {
-			if v && k == quota.NamespaceName {
-				patch = append(patch, updateResourceLimiterQuotasV1beta2(rl.Spec.Quotas, []rlv1beta2.ResourceLimiterQuota{
-					// Quotas.NamespaceName must exists, because desired is generated from the mutate-needed spec
-					{
-						NamespaceName: quota.NamespaceName,
-						CpuRequest:    "1",
-						CpuLimit:      "2",
-						MemRequest:    "150Mi",
-						MemLimit:      "200Mi",
-					},
-				})...)
-			}
-		}
+	patch, err := diffQuotas(rl.Spec.Quotas, desired.Spec.Quotas)
+	if err != nil {
+		return nil, err
 	}
-	return json.Marshal(patch)
+	infoLogger.Printf("Mutation policy for v1/%v required:%v", rl.Name, len(patch) != 0)
+	return patch, nil
 }
 
 // func setDesired(rl *rlv1beta2.ResourceLimiter) *admissionv1.AdmissionResponse {
@@ -231,118 +342,161 @@ func createPatchV1beta2(rl *rlv1beta2.ResourceLimiter, desired *rlv1beta2.Resour
 // 	}
 // }
 
+// decodeResourceLimiter decodes a v1beta2 or v1 ResourceLimiter into the v1beta2 hub,
+// patches are computed in the layout of the version of the request, see createPatchV1
+func decodeResourceLimiter(version string, raw []byte, rl *rlv1beta2.ResourceLimiter) error {
+	if version != "v1" {
+		return json.Unmarshal(raw, rl)
+	}
+	var rlv1 rlapiv1.ResourceLimiter
+	if err := json.Unmarshal(raw, &rlv1); err != nil {
+		return err
+	}
+	return rlv1.ConvertTo(rl)
+}
+
+// previousResourceLimiter decodes the ResourceLimiter an UPDATE replaces into the v1beta2 hub,
+// it is nil for any other operation
+func previousResourceLimiter(req *admissionv1.AdmissionRequest) (*rlv1beta2.ResourceLimiter, error) {
+	if req.Operation != admissionv1.Update || len(req.OldObject.Raw) == 0 {
+		return nil, nil
+	}
+	old := &rlv1beta2.ResourceLimiter{}
+	if req.Kind.Version != "v1beta1" {
+		return old, decodeResourceLimiter(req.Kind.Version, req.OldObject.Raw, old)
+	}
+	var oldv1beta1 rlv1beta1.ResourceLimiter
+	if err := json.Unmarshal(req.OldObject.Raw, &oldv1beta1); err != nil {
+		return nil, err
+	}
+	return old, oldv1beta1.ConvertTo(old)
+}
+
+// denyConflict denies a ResourceLimiter taking a namespace from another applied one which outranks it,
+// the same way the controller leaves the namespace to the ResourceLimiter that outranks the others.
+// An update is only checked for the namespaces it newly targets, so that a ResourceLimiter overlapped
+// later by another one can still be edited.
+func (whsvr *WebhookServer) denyConflict(req *admissionv1.AdmissionRequest, rl *rlv1beta2.ResourceLimiter) *admissionv1.AdmissionResponse {
+	if !whsvr.denyConflicts || whsvr.client == nil || !rl.Spec.Applied {
+		return nil
+	}
+	ctx := context.Background()
+	targeted, err := conflict.Namespaces(ctx, whsvr.client, rl)
+	if err != nil {
+		return denied(denialLookupFailed, err.Error())
+	}
+	old, err := previousResourceLimiter(req)
+	if err != nil {
+		return denied(denialDecodeFailure, err.Error())
+	}
+	if old != nil && old.Spec.Applied {
+		previous, err := conflict.Namespaces(ctx, whsvr.client, old)
+		if err != nil {
+			return denied(denialLookupFailed, err.Error())
+		}
+		for ns := range previous {
+			delete(targeted, ns)
+		}
+	}
+	namespaces := make([]string, 0, len(targeted))
+	for ns := range targeted {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	overlaps, err := conflict.Overlaps(ctx, whsvr.client, rl, namespaces)
+	if err != nil {
+		return denied(denialLookupFailed, err.Error())
+	}
+
+	candidate := rl
+	if rl.CreationTimestamp.IsZero() {
+		// A ResourceLimiter being created is ranked as the newest one
+		candidate = rl.DeepCopy()
+		candidate.CreationTimestamp = metav1.Now()
+	}
+	for _, ns := range namespaces {
+		if other, ok := overlaps[ns]; ok && conflict.Outranks(other, candidate) {
+			warningLogger.Printf("resourcelimiter %s conflicts with %s on namespace %s", rl.Name, other.Name, ns)
+			return denied(denialConflict, fmt.Sprintf("namespace %s is already targeted by resourcelimiter %s, which outranks %s", ns, other.Name, rl.Name))
+		}
+	}
+	return nil
+}
+
 // main mutation process
+// patchResponse allows the request with the JSON patch, if any
+func patchResponse(patch []patchOperation) *admissionv1.AdmissionResponse {
+	if len(patch) == 0 {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return denied(denialPatchFailed, err.Error())
+	}
+	infoLogger.Printf("AdmissionResponse: patch=%v\n", string(patchBytes))
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Patch:   patchBytes,
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
 func (whsvr *WebhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
+	if isWorkload(req.Kind.Kind) {
+		return whsvr.mutateWorkload(req)
+	}
 	switch req.Kind.Version {
 	case "v1beta1":
 		var rl rlv1beta1.ResourceLimiter
 		if err := json.Unmarshal(req.Object.Raw, &rl); err != nil {
 			warningLogger.Printf("Could not unmarshal raw object: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
+			return denied(denialDecodeFailure, err.Error())
 		}
 
 		infoLogger.Printf("Mutate AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
 			req.Kind, req.Namespace, req.Name, rl.Name, req.UID, req.Operation, req.UserInfo)
 
-		desired := rlv1beta1.ResourceLimiter{
-			Spec: rlv1beta1.ResourceLimiterSpec{
-				Types: map[rlv1beta1.ResourceLimiterType]string{constants.RetrainTypeLimitsCpu: "2", constants.RetrainTypeLimitsMemory: "200Mi",
-					constants.RetrainTypeRequestsCpu: "1", constants.RetrainTypeRequestsMemory: "150Mi"},
-				Targets: []rlv1beta1.ResourceLimiterNamespace{"default"},
-			},
-		}
-		patchBytes, err := createPatchV1beta1(&rl, &desired)
-		if err != nil {
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
-		}
-
-		infoLogger.Printf("AdmissionResponse: patch=%v\n", string(patchBytes))
-		return &admissionv1.AdmissionResponse{
-			Allowed: true,
-			Patch:   patchBytes,
-			PatchType: func() *admissionv1.PatchType {
-				pt := admissionv1.PatchTypeJSONPatch
-				return &pt
-			}(),
-		}
+		return patchResponse(whsvr.createPatchV1beta1(&rl))
 	case "v1beta2":
 		var rl rlv1beta2.ResourceLimiter
 		if err := json.Unmarshal(req.Object.Raw, &rl); err != nil {
 			warningLogger.Printf("Could not unmarshal raw object: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
+			return denied(denialDecodeFailure, err.Error())
 		}
 
 		infoLogger.Printf("Mutate AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
 			req.Kind, req.Namespace, req.Name, rl.Name, req.UID, req.Operation, req.UserInfo)
 
-		desired := rlv1beta2.ResourceLimiter{
-			Spec: rlv1beta2.ResourceLimiterSpec{
-				Quotas: []rlv1beta2.ResourceLimiterQuota{},
-			},
+		patch, err := whsvr.createPatchV1beta2(context.Background(), &rl)
+		if err != nil {
+			return denied(denialPatchFailed, err.Error())
 		}
-
-		for _, item := range rl.Spec.Quotas {
-			// We set all to default
-			// TODO maybe later I can try only change the problem field
-			desired.Spec.Quotas = append(desired.Spec.Quotas, rlv1beta2.ResourceLimiterQuota{
-				NamespaceName: item.NamespaceName,
-				// CpuRequest:    "1",
-				// CpuLimit:      "2",
-				// MemRequest:    "150Mi",
-				// MemLimit:      "200Mi",
-			})
+		return patchResponse(patch)
+	case "v1":
+		var rl rlapiv1.ResourceLimiter
+		if err := json.Unmarshal(req.Object.Raw, &rl); err != nil {
+			warningLogger.Printf("Could not unmarshal raw object: %v", err)
+			return denied(denialDecodeFailure, err.Error())
 		}
 
-		patchBytes, err := createPatchV1beta2(&rl, &desired)
-		if err != nil {
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
-		}
+		infoLogger.Printf("Mutate AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
+			req.Kind, req.Namespace, req.Name, rl.Name, req.UID, req.Operation, req.UserInfo)
 
-		infoLogger.Printf("AdmissionResponse: patch=%v\n", string(patchBytes))
-		return &admissionv1.AdmissionResponse{
-			Allowed: true,
-			Patch:   patchBytes,
-			PatchType: func() *admissionv1.PatchType {
-				pt := admissionv1.PatchTypeJSONPatch
-				return &pt
-			}(),
+		patch, err := whsvr.createPatchV1(context.Background(), &rl)
+		if err != nil {
+			return denied(denialPatchFailed, err.Error())
 		}
+		return patchResponse(patch)
 	}
-	return &admissionv1.AdmissionResponse{
-		Result: &metav1.Status{
-			Message: fmt.Sprintf("Unsupported version %s", req.Kind.Version),
-		},
-	}
-}
-
-func recordR(log *log.Logger) {
-	if err := recover(); err != nil {
-		log.Printf(fmt.Sprintf("MustParse failed due to %v", err))
-		resFormErr = fmt.Errorf("MustParse failed due to %v", err)
-	}
+	return denied(denialUnsupportedVersion, fmt.Sprintf("Unsupported version %s", req.Kind.Version))
 }
 
 func (whsvr *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
-	resFormErr = nil
-	defer recordR(warningLogger)
 
 	switch req.Kind.Kind {
 	case "ResourceLimiter":
@@ -352,166 +506,55 @@ func (whsvr *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admission
 			infoLogger.Printf("begin marshal resourcelimiter %s of %s", req.Name, req.Kind.Kind)
 			if err := json.Unmarshal(req.Object.Raw, &rl); err != nil {
 				warningLogger.Printf("Could not unmarshal raw object into resourcelimiter, try pod: %v", err)
-				return &admissionv1.AdmissionResponse{
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: err.Error(),
-					},
-				}
+				return denied(denialDecodeFailure, err.Error())
 			}
 			infoLogger.Printf("Validate AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
 				req.Kind, req.Namespace, req.Name, rl.Name, req.UID, req.Operation, req.UserInfo)
-			for _, ns := range rl.Spec.Targets {
-				if ns == constants.IgnoreKubeSystem || ns == constants.IgnoreKubePublic {
-					return &admissionv1.AdmissionResponse{
-						Allowed: false,
-						Result: &metav1.Status{
-							Message: "should avoid limit on the preset namespace",
-						},
-					}
-				}
+			if response := validateResourceLimiterV1beta1(&rl).deny(rlv1beta1.GroupVersion.WithKind(req.Kind.Kind).GroupKind(), rl.Name); response != nil {
+				warningLogger.Printf("failed to validate %s: %s", rl.Name, response.Result.Message)
+				return response
 			}
-			for t, value := range rl.Spec.Types {
-				warningLogger.Printf(fmt.Sprintf("validating type field %s for %s", t, rl.Name))
-				k8sresource.MustParse(value)
+			hub := rlv1beta2.ResourceLimiter{}
+			if err := rl.ConvertTo(&hub); err == nil {
+				hub.CreationTimestamp = rl.CreationTimestamp
+				if response := whsvr.denyConflict(req, &hub); response != nil {
+					return response
+				}
 			}
-		case "v1beta2":
+		case "v1beta2", "v1":
 			var rl rlv1beta2.ResourceLimiter
-			infoLogger.Printf("begin marshal resourcelimiter v1beta2/%s of %s", req.Name, req.Kind.Kind)
-			if err := json.Unmarshal(req.Object.Raw, &rl); err != nil {
+			infoLogger.Printf("begin marshal resourcelimiter %s/%s of %s", req.Kind.Version, req.Name, req.Kind.Kind)
+			if err := decodeResourceLimiter(req.Kind.Version, req.Object.Raw, &rl); err != nil {
 				warningLogger.Printf("Could not unmarshal raw object into resourcelimiter, try pod: %v", err)
-				return &admissionv1.AdmissionResponse{
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: err.Error(),
-					},
-				}
+				return denied(denialDecodeFailure, err.Error())
 			}
 			infoLogger.Printf("Validate AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
 				req.Kind, req.Namespace, req.Name, rl.Name, req.UID, req.Operation, req.UserInfo)
 
-			for _, quota := range rl.Spec.Quotas {
-				if quota.NamespaceName == string(constants.IgnoreKubeSystem) || quota.NamespaceName == string(constants.IgnoreKubePublic) {
-					return &admissionv1.AdmissionResponse{
-						Allowed: false,
-						Result: &metav1.Status{
-							Message: "should avoid limit on the preset namespace",
-						},
-					}
+			errs := validateResourceLimiterV1beta2(&rl)
+			if req.Kind.Version == "v1" {
+				// The shorthands are checked where v1 keeps them, quantities and percentages apart
+				var rlv1 rlapiv1.ResourceLimiter
+				if err := json.Unmarshal(req.Object.Raw, &rlv1); err != nil {
+					return denied(denialDecodeFailure, err.Error())
 				}
-				warningLogger.Printf(fmt.Sprintf("validating quota field CpuLimitfor for %s", rl.Name))
-				k8sresource.MustParse(quota.CpuLimit)
-				warningLogger.Printf(fmt.Sprintf("validating quota field CpuRequest for %s", rl.Name))
-				k8sresource.MustParse(quota.CpuRequest)
-				warningLogger.Printf(fmt.Sprintf("validating quota field MemLimit for %s", rl.Name))
-				k8sresource.MustParse(quota.MemLimit)
-				warningLogger.Printf(fmt.Sprintf("validating quota field MemRequest for %s", rl.Name))
-				k8sresource.MustParse(quota.MemRequest)
+				errs = validateResourceLimiterV1(&rlv1, &rl)
 			}
-		}
-
-	case "Pod":
-		var pod corev1.Pod
-		infoLogger.Printf("begin marshal pod %s of %s", req.Name, req.Kind.Kind)
-		if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
-			warningLogger.Printf("Could not unmarshal raw object into pod either: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
-		}
-		infoLogger.Printf("Validate AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v patchOperation=%v UserInfo=%v",
-			req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
-		for _, cont := range pod.Spec.Containers {
-			if cont.Resources.Limits == nil || len(cont.Resources.Limits) == 0 || cont.Resources.Requests == nil || len(cont.Resources.Requests) == 0 {
-				return &admissionv1.AdmissionResponse{
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("failed to validate pod %s not set any resources limits or requests", pod.Name),
-					},
-				}
+			if response := errs.deny(rlv1beta2.GroupVersion.WithKind(req.Kind.Kind).GroupKind(), rl.Name); response != nil {
+				warningLogger.Printf("failed to validate %s: %s", rl.Name, response.Result.Message)
+				return response
 			}
-			if cont.Resources.Limits != nil {
-				k8sresource.MustParse(cont.Resources.Limits.Cpu().String())
-				k8sresource.MustParse(cont.Resources.Limits.Memory().String())
-			}
-
-			if cont.Resources.Requests != nil {
-				k8sresource.MustParse(cont.Resources.Requests.Cpu().String())
-				k8sresource.MustParse(cont.Resources.Requests.Memory().String())
-			}
-
-		}
-	case "Deployment":
-		var deployment appsv1.Deployment
-		infoLogger.Printf("begin marshal deployment %s of %s", req.Name, req.Kind.Kind)
-		if err := json.Unmarshal(req.Object.Raw, &deployment); err != nil {
-			warningLogger.Printf("Could not unmarshal raw object into deployment either: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
+			if response := whsvr.denyConflict(req, &rl); response != nil {
+				return response
 			}
 		}
-		infoLogger.Printf("Validate AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v patchOperation=%v UserInfo=%v",
-			req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
-		for _, cont := range deployment.Spec.Template.Spec.Containers {
-			if cont.Resources.Limits == nil || len(cont.Resources.Limits) == 0 || cont.Resources.Requests == nil || len(cont.Resources.Requests) == 0 {
-				return &admissionv1.AdmissionResponse{
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("failed to validate deployment %s not set any resources limits or requests", deployment.Name),
-					},
-				}
-			}
-			if cont.Resources.Limits != nil {
-				k8sresource.MustParse(cont.Resources.Limits.Cpu().String())
-				k8sresource.MustParse(cont.Resources.Limits.Memory().String())
-			}
 
-			if cont.Resources.Requests != nil {
-				k8sresource.MustParse(cont.Resources.Requests.Cpu().String())
-				k8sresource.MustParse(cont.Resources.Requests.Memory().String())
-			}
-		}
-	case "Daemonset":
-		var daemonset appsv1.DaemonSet
-		infoLogger.Printf("begin marshal daemonset %s of %s", req.Name, req.Kind.Kind)
-		if err := json.Unmarshal(req.Object.Raw, &daemonset); err != nil {
-			warningLogger.Printf("Could not unmarshal raw object into daemonset either: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
-		}
-		infoLogger.Printf("Validate AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v patchOperation=%v UserInfo=%v",
-			req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
-		for _, cont := range daemonset.Spec.Template.Spec.Containers {
-			if cont.Resources.Limits == nil || len(cont.Resources.Limits) == 0 || cont.Resources.Requests == nil || len(cont.Resources.Requests) == 0 {
-				return &admissionv1.AdmissionResponse{
-					Allowed: false,
-					Result: &metav1.Status{
-						Message: fmt.Sprintf("failed to validate daemonset %s not set any resources limits or requests", daemonset.Name),
-					},
-				}
-			}
-			if cont.Resources.Limits != nil {
-				k8sresource.MustParse(cont.Resources.Limits.Cpu().String())
-				k8sresource.MustParse(cont.Resources.Limits.Memory().String())
-			}
-
-			if cont.Resources.Requests != nil {
-				k8sresource.MustParse(cont.Resources.Requests.Cpu().String())
-				k8sresource.MustParse(cont.Resources.Requests.Memory().String())
-			}
-		}
 	default:
-		warningLogger.Printf("should not been here")
+		if !isWorkload(req.Kind.Kind) {
+			warningLogger.Printf("should not been here")
+			break
+		}
+		return whsvr.validateWorkload(req)
 	}
 
 	return &admissionv1.AdmissionResponse{
@@ -524,6 +567,7 @@ func (whsvr *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admission
 
 // Serve method for webhook server
 func (whsvr *WebhookServer) ServeMutate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	infoLogger.Printf("begin mutating webhook check")
 	var body []byte
 	if r.Body != nil {
@@ -549,15 +593,13 @@ func (whsvr *WebhookServer) ServeMutate(w http.ResponseWriter, r *http.Request)
 	ar := admissionv1.AdmissionReview{}
 	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
 		warningLogger.Printf("Can't decode body: %v", err)
-		admissionResponse = &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+		admissionResponse = denied(denialDecodeFailure, err.Error())
 	} else {
 		admissionResponse = whsvr.mutate(&ar)
 	}
 
+	recordAdmission(webhookMutate, ar.Request, admissionResponse, start)
+
 	admissionReview := admissionv1.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "admission.k8s.io/v1",
@@ -585,6 +627,7 @@ func (whsvr *WebhookServer) ServeMutate(w http.ResponseWriter, r *http.Request)
 
 // Serve method for webhook server
 func (whsvr *WebhookServer) ServeValidate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	infoLogger.Printf("begin validating webhook check")
 	var body []byte
 	if r.Body != nil {
@@ -610,26 +653,12 @@ func (whsvr *WebhookServer) ServeValidate(w http.ResponseWriter, r *http.Request
 	ar := admissionv1.AdmissionReview{}
 	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
 		warningLogger.Printf("Can't decode body: %v", err)
-		admissionResponse = &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+		admissionResponse = denied(denialDecodeFailure, err.Error())
 	} else {
 		admissionResponse = whsvr.validate(&ar)
 	}
 
-	// For parse panic
-	if resFormErr != nil {
-		warningLogger.Printf("failed to parse resources fields")
-		admissionResponse = &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: resFormErr.Error(),
-			},
-		}
-	}
+	recordAdmission(webhookValidate, ar.Request, admissionResponse, start)
 
 	admissionReview := admissionv1.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
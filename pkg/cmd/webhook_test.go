@@ -1,20 +1,38 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	jsonpatch "github.com/evanphx/json-patch"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -50,10 +68,11 @@ var _ = Describe("ResourceLimiter Webhooks", func() {
 					Quotas: []rlv1beta2.ResourceLimiterQuota{
 						{
 							NamespaceName: "default",
-							CpuRequest:    "1",
-							CpuLimit:      "2",
-							MemLimit:      "200Mi",
-							MemRequest:    "150Mi",
+							// Only the missing request is filled, capped by the limit already set
+							CpuRequest: "100m",
+							CpuLimit:   "100m",
+							MemLimit:   "200Mi",
+							MemRequest: "100Mi",
 						},
 					},
 				},
@@ -89,6 +108,274 @@ var _ = Describe("ResourceLimiter Webhooks", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(reflect.DeepEqual(patchedResourceLimiter.Spec.Quotas, desiredResourceLimiter.Spec.Quotas)).To(Equal(true))
 		})
+
+		DescribeTable("Should patch the missing fields of a target in place",
+			func(version string, missing []string) {
+				output, patch := missingFieldsPatch(version, missing)
+				golden := filepath.Join("testdata", "patches", version, goldenName(missing))
+				if *updateGolden {
+					Expect(os.WriteFile(golden, patch, 0o644)).To(Succeed())
+				}
+				expected, err := os.ReadFile(golden)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(patch)).To(Equal(string(expected)))
+
+				decoded, err := jsonpatch.DecodePatch(patch)
+				Expect(err).NotTo(HaveOccurred())
+				patched, err := decoded.Apply(output)
+				Expect(err).NotTo(HaveOccurred())
+				if version == "v1" {
+					defaulted := rlapiv1.ResourceLimiter{}
+					Expect(json.Unmarshal(patched, &defaulted)).To(Succeed())
+					Expect(defaulted.Spec.Quotas).To(HaveLen(1))
+					Expect(defaulted.Spec.Quotas[0].NamespaceName).To(Equal("default"))
+					Expect(defaulted.Spec.Quotas[0].Percentages).To(BeNil())
+					for _, value := range []*k8sresource.Quantity{defaulted.Spec.Quotas[0].CpuRequest, defaulted.Spec.Quotas[0].CpuLimit,
+						defaulted.Spec.Quotas[0].MemRequest, defaulted.Spec.Quotas[0].MemLimit} {
+						Expect(value).NotTo(BeNil())
+					}
+					return
+				}
+				defaulted := rlv1beta2.ResourceLimiter{}
+				Expect(json.Unmarshal(patched, &defaulted)).To(Succeed())
+				Expect(defaulted.Spec.Quotas).To(HaveLen(1))
+				Expect(defaulted.Spec.Quotas[0].NamespaceName).To(Equal("default"))
+				for _, value := range []string{defaulted.Spec.Quotas[0].CpuRequest, defaulted.Spec.Quotas[0].CpuLimit,
+					defaulted.Spec.Quotas[0].MemRequest, defaulted.Spec.Quotas[0].MemLimit} {
+					Expect(value).NotTo(BeEmpty())
+				}
+			},
+			append(missingFieldsEntries("v1beta2"), missingFieldsEntries("v1")...)...,
+		)
+
+		It("Should fill the missing fields from the defaults ConfigMap", func() {
+			configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			Expect(configMaps.Add(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "rl-checker-defaults", Namespace: "rl-system"},
+				Data: map[string]string{
+					defaultsKey: `
+quota:
+  cpu_requests: 500m
+overrides:
+- namespaceSelector:
+    matchLabels:
+      kubernetes.io/metadata.name: default
+  quota:
+    mem_limits: 1Gi
+`,
+				},
+			})).To(Succeed())
+			defaultsWebhookServer := WebhookServer{
+				server:            &http.Server{},
+				client:            k8sClient,
+				configMaps:        corelisters.NewConfigMapLister(configMaps),
+				defaultsNamespace: "rl-system",
+				defaultsName:      "rl-checker-defaults",
+			}
+			mutatePatch := func(version string, rl interface{}) []patchOperation {
+				output, err := json.Marshal(rl)
+				Expect(err).NotTo(HaveOccurred())
+				response := defaultsWebhookServer.mutate(&admissionv1.AdmissionReview{
+					Request: &admissionv1.AdmissionRequest{
+						Kind:   metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: version},
+						Object: runtime.RawExtension{Raw: output},
+					},
+				})
+				Expect(response.Allowed).To(Equal(true))
+				patch := []patchOperation{}
+				Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+				return patch
+			}
+
+			Expect(mutatePatch("v1beta2", rlv1beta2.ResourceLimiter{
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{NamespaceName: "default", CpuLimit: "2"},
+						{NamespaceName: "test-unknown-namespace", Hard: corev1.ResourceList{corev1.ResourceRequestsMemory: k8sresource.MustParse("64Mi")}},
+					},
+				},
+			})).To(Equal([]patchOperation{
+				{Op: "add", Path: "/spec/targets/0/cpu_requests", Value: "500m"},
+				{Op: "add", Path: "/spec/targets/0/mem_limits", Value: "1Gi"},
+				{Op: "add", Path: "/spec/targets/0/mem_requests", Value: "150Mi"},
+				{Op: "add", Path: "/spec/targets/1/cpu_limits", Value: "2"},
+				{Op: "add", Path: "/spec/targets/1/cpu_requests", Value: "500m"},
+				{Op: "add", Path: "/spec/targets/1/mem_limits", Value: "200Mi"},
+			}))
+
+			// A percentage is never paired with a default quantity
+			Expect(mutatePatch("v1beta2", rlv1beta2.ResourceLimiter{
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{NamespaceName: "test-unknown-namespace", CpuRequest: "15%"},
+					},
+				},
+			})).To(Equal([]patchOperation{
+				{Op: "add", Path: "/spec/targets/0/mem_limits", Value: "200Mi"},
+				{Op: "add", Path: "/spec/targets/0/mem_requests", Value: "150Mi"},
+			}))
+
+			// The v1 percentages stay in their own struct, the defaults are patched as quantities
+			Expect(mutatePatch("v1", rlapiv1.ResourceLimiter{
+				Spec: rlapiv1.ResourceLimiterSpec{
+					Quotas: []rlapiv1.ResourceLimiterQuota{
+						{NamespaceName: "default", Percentages: &rlapiv1.ResourcePercentages{CpuRequest: "15%"}},
+					},
+				},
+			})).To(Equal([]patchOperation{
+				{Op: "add", Path: "/spec/targets/0/mem_limits", Value: "1Gi"},
+				{Op: "add", Path: "/spec/targets/0/mem_requests", Value: "150Mi"},
+			}))
+
+			// A defaulted limit is raised to the request already set
+			Expect(mutatePatch("v1beta1", rlv1beta1.ResourceLimiter{
+				Spec: rlv1beta1.ResourceLimiterSpec{
+					Types: map[rlv1beta1.ResourceLimiterType]string{"requests.cpu": "4"},
+				},
+			})).To(Equal([]patchOperation{
+				{Op: "add", Path: "/spec/types/limits.cpu", Value: "4"},
+				{Op: "add", Path: "/spec/types/limits.memory", Value: "200Mi"},
+				{Op: "add", Path: "/spec/types/requests.memory", Value: "150Mi"},
+				{Op: "add", Path: "/spec/targets", Value: []interface{}{"default"}},
+			}))
+		})
+
+		It("Should patch a v1 ResourceLimiter in the v1 layout", func() {
+			v1ResourceLimiter := rlapiv1.ResourceLimiter{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "resources.resourcelimiter.io/v1",
+					Kind:       "ResourceLimiter",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-v1-percentages",
+				},
+				Spec: rlapiv1.ResourceLimiterSpec{
+					Quotas: []rlapiv1.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							Percentages:   &rlapiv1.ResourcePercentages{CpuLimit: "15%"},
+						},
+					},
+				},
+			}
+			output, err := json.Marshal(v1ResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := mockWebhookServer.mutate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind:   metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: "v1"},
+					Object: runtime.RawExtension{Raw: output},
+				},
+			})
+			Expect(response.Allowed).To(Equal(true))
+			patch := []patchOperation{}
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			// The percentage is never paired with a default quantity, the memory is defaulted as quantities
+			Expect(patch).To(Equal([]patchOperation{
+				{Op: "add", Path: "/spec/targets/0/mem_limits", Value: "200Mi"},
+				{Op: "add", Path: "/spec/targets/0/mem_requests", Value: "150Mi"},
+			}))
+
+			decoded, err := jsonpatch.DecodePatch(response.Patch)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := decoded.Apply(output)
+			Expect(err).NotTo(HaveOccurred())
+			defaulted := rlapiv1.ResourceLimiter{}
+			Expect(json.Unmarshal(patched, &defaulted)).To(Succeed())
+			Expect(defaulted.Spec.Quotas[0].Percentages).To(Equal(&rlapiv1.ResourcePercentages{CpuLimit: "15%"}))
+			Expect(defaulted.Spec.Quotas[0].CpuRequest).To(BeNil())
+			Expect(defaulted.Spec.Quotas[0].CpuLimit).To(BeNil())
+			Expect(defaulted.Spec.Quotas[0].MemRequest.String()).To(Equal("150Mi"))
+			Expect(defaulted.Spec.Quotas[0].MemLimit.String()).To(Equal("200Mi"))
+		})
+
+		It("Should fill the resources missing from a deployment with the container defaults", func() {
+			defaultingResourceLimiter := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-container-defaults",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Applied: true,
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							ContainerDefaults: &corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    k8sresource.MustParse("100m"),
+									corev1.ResourceMemory: k8sresource.MustParse("128Mi"),
+								},
+								Limits: corev1.ResourceList{
+									corev1.ResourceCPU:    k8sresource.MustParse("500m"),
+									corev1.ResourceMemory: k8sresource.MustParse("256Mi"),
+								},
+							},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &defaultingResourceLimiter)).Should(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, &defaultingResourceLimiter)).Should(Succeed())
+			}()
+
+			deployment := appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-defaulted-deployment",
+					Namespace: "default",
+				},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Name: "without-resources",
+								},
+								{
+									Name: "with-low-cpu-limit",
+									Resources: corev1.ResourceRequirements{
+										Limits: corev1.ResourceList{
+											corev1.ResourceCPU: k8sresource.MustParse("50m"),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+			output, err := json.Marshal(deployment)
+			Expect(err).NotTo(HaveOccurred())
+
+			defaultingWebhookServer := WebhookServer{
+				server: &http.Server{},
+				client: k8sClient,
+			}
+			response := defaultingWebhookServer.mutate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+					Namespace: "default",
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Allowed).To(Equal(true))
+
+			patch, err := jsonpatch.DecodePatch(response.Patch)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := patch.Apply(output)
+			Expect(err).NotTo(HaveOccurred())
+			defaulted := appsv1.Deployment{}
+			Expect(json.Unmarshal(patched, &defaulted)).To(Succeed())
+
+			containers := defaulted.Spec.Template.Spec.Containers
+			Expect(containers[0].Resources.Requests.Cpu().String()).To(Equal("100m"))
+			Expect(containers[0].Resources.Limits.Memory().String()).To(Equal("256Mi"))
+			// The default request is capped by the limit the container already sets
+			Expect(containers[1].Resources.Requests.Cpu().String()).To(Equal("50m"))
+			Expect(containers[1].Resources.Limits.Cpu().String()).To(Equal("50m"))
+			Expect(containers[1].Resources.Limits.Memory().String()).To(Equal("256Mi"))
+		})
 	})
 	Context("Validate Webhook Check", func() {
 		mockWebhookServer := WebhookServer{
@@ -128,9 +415,265 @@ var _ = Describe("ResourceLimiter Webhooks", func() {
 					},
 				},
 			}
-			_ = mockWebhookServer.validate(&ar)
-			// Expect(response.Allowed).To(Equal(false))
-			Expect(resFormErr).To(HaveOccurred())
+			response := mockWebhookServer.validate(&ar)
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.AuditAnnotations[denialReasonAnnotation]).To(Equal(denialInvalidQuantity))
+			Expect(response.Result.Message).To(ContainSubstring("spec.targets[0].cpu_requests"))
+		})
+
+		It("Should report every problem of a ResourceLimiter v1beta2 at once", func() {
+			invalidResourceLimiter := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-every-problem",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{NamespaceName: "kube-system", CpuRequest: "100m", CpuLimit: "200m"},
+						{NamespaceName: "default", CpuRequest: "1cpu", CpuLimit: "200m"},
+						{NamespaceName: "default", MemRequest: "512Mi", MemLimit: "256Mi"},
+						{CpuRequest: "100m"},
+					},
+				},
+			}
+			output, err := json.Marshal(invalidResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := mockWebhookServer.validate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: "v1beta2"},
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.AuditAnnotations[denialReasonAnnotation]).To(Equal(denialProtectedNamespace))
+			Expect(response.Result.Reason).To(Equal(metav1.StatusReasonInvalid))
+			Expect(response.Result.Details).NotTo(BeNil())
+			causes := map[string]metav1.CauseType{}
+			for _, cause := range response.Result.Details.Causes {
+				causes[cause.Field] = cause.Type
+			}
+			Expect(causes).To(Equal(map[string]metav1.CauseType{
+				"spec.targets[0].name":         metav1.CauseType(field.ErrorTypeForbidden),
+				"spec.targets[1].cpu_requests": metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[2].name":         metav1.CauseType(field.ErrorTypeDuplicate),
+				"spec.targets[2].mem_requests": metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[3].name":         metav1.CauseType(field.ErrorTypeRequired),
+			}))
+		})
+
+		It("Should validate the hard resources of ResourceLimiter v1beta2", func() {
+			appliedResourceLimiterWithFalseResource := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-wrong-hard-resource",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Applied: true,
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							Hard: corev1.ResourceList{
+								"requests.storage": k8sresource.MustParse("10Gi"),
+								"count/pods!":      k8sresource.MustParse("10"),
+							},
+						},
+					},
+				},
+			}
+
+			output, err := json.Marshal(appliedResourceLimiterWithFalseResource)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(output)).NotTo(Equal(0))
+
+			ar := admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{
+						Kind:    "ResourceLimiter",
+						Version: "v1beta2",
+					},
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			}
+			response := mockWebhookServer.validate(&ar)
+			Expect(response.Allowed).To(Equal(false))
+		})
+
+		It("Should validate the percentages of ResourceLimiter v1beta2", func() {
+			percentResourceLimiter := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-percentages",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "general"}},
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{NamespaceName: "default", CpuRequest: "10%", CpuLimit: "15%", MemRequest: "1Gi", MemLimit: "2Gi"},
+						{NamespaceName: "rl-percent-request", CpuRequest: "20%", CpuLimit: "15%"},
+						{NamespaceName: "rl-percent-malformed", CpuLimit: "abc%", MemLimit: "150%"},
+						{NamespaceName: "rl-percent-mixed", CpuRequest: "500m", CpuLimit: "15%", MemLimit: "12.5%"},
+					},
+				},
+			}
+			output, err := json.Marshal(percentResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := mockWebhookServer.validate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: "v1beta2"},
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.AuditAnnotations[denialReasonAnnotation]).To(Equal(denialInvalidQuantity))
+			causes := map[string]metav1.CauseType{}
+			for _, cause := range response.Result.Details.Causes {
+				causes[cause.Field] = cause.Type
+			}
+			Expect(causes).To(Equal(map[string]metav1.CauseType{
+				"spec.targets[1].cpu_requests": metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[2].cpu_limits":   metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[2].mem_limits":   metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[3].cpu_requests": metav1.CauseType(field.ErrorTypeInvalid),
+			}))
+		})
+
+		It("Should validate the percentages of ResourceLimiter v1 where they are set", func() {
+			cpuLimit := k8sresource.MustParse("1")
+			percentResourceLimiter := rlapiv1.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-v1-percentages",
+				},
+				Spec: rlapiv1.ResourceLimiterSpec{
+					NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "general"}},
+					Quotas: []rlapiv1.ResourceLimiterQuota{
+						{NamespaceName: "default", Percentages: &rlapiv1.ResourcePercentages{CpuRequest: "10%", CpuLimit: "15%"}},
+						{NamespaceName: "rl-percent-request", Percentages: &rlapiv1.ResourcePercentages{CpuRequest: "20%", CpuLimit: "15%"}},
+						{NamespaceName: "rl-percent-mixed", CpuLimit: &cpuLimit, Percentages: &rlapiv1.ResourcePercentages{CpuRequest: "15%"}},
+						{NamespaceName: "rl-percent-both", CpuLimit: &cpuLimit, Percentages: &rlapiv1.ResourcePercentages{CpuLimit: "15%"}},
+					},
+				},
+			}
+			output, err := json.Marshal(percentResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := mockWebhookServer.validate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: "v1"},
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Allowed).To(Equal(false))
+			causes := map[string]metav1.CauseType{}
+			for _, cause := range response.Result.Details.Causes {
+				causes[cause.Field] = cause.Type
+			}
+			Expect(causes).To(Equal(map[string]metav1.CauseType{
+				"spec.targets[1].percentages.cpu_requests": metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[2].percentages.cpu_requests": metav1.CauseType(field.ErrorTypeInvalid),
+				"spec.targets[3].cpu_limits":               metav1.CauseType(field.ErrorTypeInvalid),
+			}))
+		})
+
+		It("Should compare the watermarks of a budget rebalance with their defaults", func() {
+			budgetResourceLimiter := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-rebalance-watermarks",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{NamespaceName: "default"},
+					},
+					Budget: &rlv1beta2.ResourceBudget{
+						Hard: corev1.ResourceList{corev1.ResourceLimitsCPU: k8sresource.MustParse("4")},
+						// The lowWatermark left unset is 50, above this highWatermark
+						Rebalance: &rlv1beta2.BudgetRebalance{HighWatermark: 40},
+					},
+				},
+			}
+			output, err := json.Marshal(budgetResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := mockWebhookServer.validate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: "v1beta2"},
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.AuditAnnotations[denialReasonAnnotation]).To(Equal(denialInvalidTarget))
+			Expect(response.Result.Details.Causes).To(HaveLen(1))
+			Expect(response.Result.Details.Causes[0].Field).To(Equal("spec.budget.rebalance.lowWatermark"))
+		})
+
+		It("Should deny a ResourceLimiter conflicting with an existing one", func() {
+			ctx := context.Background()
+			existingResourceLimiter := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-conflict-existing",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Applied: true,
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							CpuLimit:      "100m",
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &existingResourceLimiter)).Should(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, &existingResourceLimiter)).Should(Succeed())
+			}()
+
+			conflictingResourceLimiter := existingResourceLimiter.DeepCopy()
+			conflictingResourceLimiter.ObjectMeta = metav1.ObjectMeta{Name: "test-conflict-new"}
+			output, err := json.Marshal(conflictingResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+
+			ar := admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{
+						Kind:    "ResourceLimiter",
+						Version: "v1beta2",
+					},
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			}
+			Expect(mockWebhookServer.validate(&ar).Allowed).To(Equal(true))
+
+			denyingWebhookServer := WebhookServer{
+				server:        &http.Server{},
+				client:        k8sClient,
+				denyConflicts: true,
+			}
+			response := denyingWebhookServer.validate(&ar)
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("test-conflict-existing"))
+
+			// An update targeting no new namespace is not checked again
+			ar.Request.Operation = admissionv1.Update
+			ar.Request.OldObject = runtime.RawExtension{Raw: output}
+			Expect(denyingWebhookServer.validate(&ar).Allowed).To(Equal(true))
+
+			// A higher priority wins the namespace as it does in the controller
+			conflictingResourceLimiter.Spec.Priority = 10
+			output, err = json.Marshal(conflictingResourceLimiter)
+			Expect(err).NotTo(HaveOccurred())
+			ar.Request.Operation = admissionv1.Create
+			ar.Request.OldObject = runtime.RawExtension{}
+			ar.Request.Object = runtime.RawExtension{Raw: output}
+			Expect(denyingWebhookServer.validate(&ar).Allowed).To(Equal(true))
 		})
 
 		It("Should validate the right ResourceLimiter v1beta1", func() {
@@ -167,8 +710,9 @@ var _ = Describe("ResourceLimiter Webhooks", func() {
 					},
 				},
 			}
-			_ = mockWebhookServer.validate(&ar)
-			Expect(resFormErr).To(HaveOccurred())
+			response := mockWebhookServer.validate(&ar)
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("spec.types[mem_requests]"))
 		})
 
 		It("Should validate the right pod", func() {
@@ -285,7 +829,7 @@ var _ = Describe("ResourceLimiter Webhooks", func() {
 			ar := admissionv1.AdmissionReview{
 				Request: &admissionv1.AdmissionRequest{
 					Kind: metav1.GroupVersionKind{
-						Kind: "Daemonset",
+						Kind: "DaemonSet",
 					},
 					Object: runtime.RawExtension{
 						Raw: output,
@@ -295,5 +839,514 @@ var _ = Describe("ResourceLimiter Webhooks", func() {
 			response := mockWebhookServer.validate(&ar)
 			Expect(response.Allowed).To(Equal(false))
 		})
+
+		DescribeTable("Should check the containers of every workload kind",
+			func(kind string, resources corev1.ResourceRequirements, allowed bool) {
+				output, err := json.Marshal(workloadOf(kind, corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:      "test-container",
+							Resources: resources,
+						},
+					},
+				}))
+				Expect(err).NotTo(HaveOccurred())
+
+				response := mockWebhookServer.validate(&admissionv1.AdmissionReview{
+					Request: &admissionv1.AdmissionRequest{
+						Kind: metav1.GroupVersionKind{
+							Kind: kind,
+						},
+						Object: runtime.RawExtension{
+							Raw: output,
+						},
+					},
+				})
+				Expect(response.Allowed).To(Equal(allowed))
+				if !allowed {
+					Expect(response.AuditAnnotations[denialReasonAnnotation]).To(Equal(denialMissingResources))
+					Expect(response.Result.Message).To(ContainSubstring(strings.ToLower(kind)))
+				}
+			},
+			Entry("Pod without resources", "Pod", corev1.ResourceRequirements{}, false),
+			Entry("Pod with resources", "Pod", withResources, true),
+			Entry("Deployment without resources", "Deployment", corev1.ResourceRequirements{}, false),
+			Entry("Deployment with resources", "Deployment", withResources, true),
+			Entry("StatefulSet without resources", "StatefulSet", corev1.ResourceRequirements{}, false),
+			Entry("StatefulSet with resources", "StatefulSet", withResources, true),
+			Entry("DaemonSet without resources", "DaemonSet", corev1.ResourceRequirements{}, false),
+			Entry("DaemonSet with resources", "DaemonSet", withResources, true),
+			Entry("ReplicaSet without resources", "ReplicaSet", corev1.ResourceRequirements{}, false),
+			Entry("ReplicaSet with resources", "ReplicaSet", withResources, true),
+			Entry("ReplicationController without resources", "ReplicationController", corev1.ResourceRequirements{}, false),
+			Entry("ReplicationController with resources", "ReplicationController", withResources, true),
+			Entry("Job without resources", "Job", corev1.ResourceRequirements{}, false),
+			Entry("Job with resources", "Job", withResources, true),
+			Entry("CronJob without resources", "CronJob", corev1.ResourceRequirements{}, false),
+			Entry("CronJob with resources", "CronJob", withResources, true),
+			Entry("Pod with requests only", "Pod", corev1.ResourceRequirements{Requests: withResources.Requests}, false),
+		)
+
+		It("Should check init containers and report the effective pod footprint", func() {
+			cpu := func(request string) corev1.ResourceRequirements {
+				return corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse(request)},
+					Limits:   corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse("1")},
+				}
+			}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-init-containers",
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{Name: "sidecar", Resources: cpu("50m")},
+						{Name: "migrate", Resources: cpu("500m")},
+					},
+					Containers: []corev1.Container{
+						{Name: "app", Resources: cpu("100m")},
+						{Name: "worker", Resources: cpu("200m")},
+					},
+				},
+			}
+			// restartPolicy of init containers is newer than the API types, set it in the raw object
+			validatePod := func(pod corev1.Pod, subResource string) *admissionv1.AdmissionResponse {
+				output, err := json.Marshal(pod)
+				Expect(err).NotTo(HaveOccurred())
+				obj := map[string]interface{}{}
+				Expect(json.Unmarshal(output, &obj)).To(Succeed())
+				if initContainers, ok := obj["spec"].(map[string]interface{})["initContainers"].([]interface{}); ok {
+					initContainers[0].(map[string]interface{})["restartPolicy"] = "Always"
+				}
+				output, err = json.Marshal(obj)
+				Expect(err).NotTo(HaveOccurred())
+				return mockWebhookServer.validate(&admissionv1.AdmissionReview{
+					Request: &admissionv1.AdmissionRequest{
+						Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+						SubResource: subResource,
+						Object: runtime.RawExtension{
+							Raw: output,
+						},
+					},
+				})
+			}
+
+			response := validatePod(pod, "")
+			Expect(response.Allowed).To(Equal(true))
+			// The migration runs next to the sidecar: 550m is above the 50m+100m+200m of the app containers
+			Expect(response.Result.Message).To(ContainSubstring("request cpu=550m"))
+
+			withoutLimits := pod.DeepCopy()
+			withoutLimits.Spec.InitContainers[0].Resources = corev1.ResourceRequirements{}
+			response = validatePod(*withoutLimits, "")
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("sidecar container sidecar"))
+
+			withoutLimits = pod.DeepCopy()
+			withoutLimits.Spec.InitContainers[1].Resources = corev1.ResourceRequirements{}
+			response = validatePod(*withoutLimits, "")
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("init container migrate"))
+
+			// Every container missing its resources is reported at once
+			withoutLimits.Spec.Containers[1].Resources = corev1.ResourceRequirements{}
+			response = validatePod(*withoutLimits, "")
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.AuditAnnotations[denialReasonAnnotation]).To(Equal(denialMissingResources))
+			fields := []string{}
+			for _, cause := range response.Result.Details.Causes {
+				fields = append(fields, cause.Field)
+			}
+			Expect(fields).To(Equal([]string{"spec.initContainers[1].resources", "spec.containers[1].resources"}))
+
+			debugged := pod.DeepCopy()
+			debugged.Spec.EphemeralContainers = []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}},
+			}
+			Expect(validatePod(*debugged, "ephemeralcontainers").Allowed).To(Equal(true))
+		})
+		It("Should enforce the resource policy of the namespace", func() {
+			policyResourceLimiter := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-resource-policy",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Applied: true,
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							Policy: &rlv1beta2.ResourcePolicy{
+								Container: &rlv1beta2.ResourceBounds{
+									Min: corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse("50m")},
+									Max: corev1.ResourceList{corev1.ResourceMemory: k8sresource.MustParse("512Mi")},
+								},
+								Pod: &rlv1beta2.ResourceBounds{
+									Max: corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse("500m")},
+								},
+								MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse("2")},
+							},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &policyResourceLimiter)).Should(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, &policyResourceLimiter)).Should(Succeed())
+			}()
+
+			policyWebhookServer := WebhookServer{
+				server: &http.Server{},
+				client: k8sClient,
+			}
+			resources := func(request, limit, memory string) corev1.ResourceRequirements {
+				return corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse(request), corev1.ResourceMemory: k8sresource.MustParse(memory)},
+					Limits:   corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse(limit), corev1.ResourceMemory: k8sresource.MustParse(memory)},
+				}
+			}
+			validateDeployment := func(containers ...corev1.Container) *admissionv1.AdmissionResponse {
+				output, err := json.Marshal(workloadOf("Deployment", corev1.PodSpec{Containers: containers}))
+				Expect(err).NotTo(HaveOccurred())
+				return policyWebhookServer.validate(&admissionv1.AdmissionReview{
+					Request: &admissionv1.AdmissionRequest{
+						Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+						Namespace: "default",
+						Operation: admissionv1.Create,
+						Object:    runtime.RawExtension{Raw: output},
+					},
+				})
+			}
+
+			Expect(validateDeployment(corev1.Container{Name: "app", Resources: resources("100m", "200m", "256Mi")}).Allowed).To(Equal(true))
+
+			response := validateDeployment(corev1.Container{Name: "app", Resources: resources("10m", "20m", "256Mi")})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("container app cpu request 10m is below the container min 50m"))
+
+			response = validateDeployment(corev1.Container{Name: "app", Resources: resources("100m", "200m", "1Gi")})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("container app memory limit 1Gi exceeds the container max 512Mi"))
+
+			response = validateDeployment(corev1.Container{Name: "app", Resources: resources("100m", "300m", "256Mi")})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("container app cpu limit 300m is more than 2 times the request 100m"))
+
+			response = validateDeployment(
+				corev1.Container{Name: "app", Resources: resources("200m", "300m", "256Mi")},
+				corev1.Container{Name: "worker", Resources: resources("200m", "300m", "256Mi")},
+			)
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("pod cpu limit 600m exceeds the pod max 500m"))
+		})
+		It("Should deny a ResourceLimiter policy bounding unsupported resources", func() {
+			invalidPolicy := rlv1beta2.ResourceLimiter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-invalid-policy",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							Policy: &rlv1beta2.ResourcePolicy{
+								Container: &rlv1beta2.ResourceBounds{
+									Max: corev1.ResourceList{"nvidia.com/gpu": k8sresource.MustParse("1")},
+								},
+							},
+						},
+					},
+				},
+			}
+			output, err := json.Marshal(invalidPolicy)
+			Expect(err).NotTo(HaveOccurred())
+			response := mockWebhookServer.validate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind:   metav1.GroupVersionKind{Group: "resources.resourcelimiter.io", Version: "v1beta2", Kind: "ResourceLimiter"},
+					Object: runtime.RawExtension{Raw: output},
+				},
+			})
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("spec.targets[0].policy.container.max[nvidia.com/gpu]: Unsupported value"))
+		})
+	})
+	Context("Quota Admission", func() {
+		quotas := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		BeforeEach(func() {
+			Expect(quotas.Add(&corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "rl-quota-default", Namespace: "default"},
+				Spec: corev1.ResourceQuotaSpec{
+					Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: k8sresource.MustParse("1")},
+				},
+				Status: corev1.ResourceQuotaStatus{
+					Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: k8sresource.MustParse("1")},
+					Used: corev1.ResourceList{corev1.ResourceRequestsCPU: k8sresource.MustParse("600m")},
+				},
+			})).To(Succeed())
+		})
+		deploymentOf := func(replicas int32) []byte {
+			deployment := workloadOf("Deployment", corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Resources: withResources}},
+			}).(appsv1.Deployment)
+			deployment.Spec.Replicas = &replicas
+			output, err := json.Marshal(deployment)
+			Expect(err).NotTo(HaveOccurred())
+			return output
+		}
+		admit := func(mode string, operation admissionv1.Operation, object, oldObject []byte) *admissionv1.AdmissionResponse {
+			quotaWebhookServer := WebhookServer{
+				server:         &http.Server{},
+				quotas:         corelisters.NewResourceQuotaLister(quotas),
+				quotaAdmission: mode,
+			}
+			return quotaWebhookServer.validate(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+					Namespace: "default",
+					Operation: operation,
+					Object:    runtime.RawExtension{Raw: object},
+					OldObject: runtime.RawExtension{Raw: oldObject},
+				},
+			})
+		}
+
+		It("Should deny a deployment whose replicas and surge exceed the remaining quota", func() {
+			// 4 replicas plus a 25% surge of 100m each need 500m while 400m remains
+			response := admit(quotaAdmissionDeny, admissionv1.Create, deploymentOf(4), nil)
+			Expect(response.Allowed).To(Equal(false))
+			Expect(response.Result.Message).To(ContainSubstring("requests.cpu needs 500m but 400m remains"))
+
+			response = admit(quotaAdmissionWarn, admissionv1.Create, deploymentOf(4), nil)
+			Expect(response.Allowed).To(Equal(true))
+			Expect(response.Warnings).To(HaveLen(1))
+
+			response = admit(quotaAdmissionOff, admissionv1.Create, deploymentOf(4), nil)
+			Expect(response.Allowed).To(Equal(true))
+			Expect(response.Warnings).To(BeEmpty())
+		})
+		It("Should only account the added replicas of an update", func() {
+			response := admit(quotaAdmissionDeny, admissionv1.Update, deploymentOf(4), deploymentOf(3))
+			Expect(response.Allowed).To(Equal(true))
+			Expect(response.Warnings).To(BeEmpty())
+
+			response = admit(quotaAdmissionDeny, admissionv1.Update, deploymentOf(8), deploymentOf(3))
+			Expect(response.Allowed).To(Equal(false))
+		})
+	})
+	Context("Concurrent Validation", func() {
+		mockWebhookServer := WebhookServer{
+			server: &http.Server{},
+		}
+		It("Should answer every review with its own verdict under concurrent requests", func() {
+			reviewOf := func(uid string, cpuRequest string) []byte {
+				rl := rlv1beta2.ResourceLimiter{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-concurrent-" + uid,
+					},
+					Spec: rlv1beta2.ResourceLimiterSpec{
+						Quotas: []rlv1beta2.ResourceLimiterQuota{
+							{
+								NamespaceName: "default",
+								CpuRequest:    cpuRequest,
+								CpuLimit:      "1",
+							},
+						},
+					},
+				}
+				output, err := json.Marshal(rl)
+				Expect(err).NotTo(HaveOccurred())
+				body, err := json.Marshal(admissionv1.AdmissionReview{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: "admission.k8s.io/v1",
+						Kind:       "AdmissionReview",
+					},
+					Request: &admissionv1.AdmissionRequest{
+						UID:  types.UID(uid),
+						Kind: metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: "v1beta2"},
+						Object: runtime.RawExtension{
+							Raw: output,
+						},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return body
+			}
+
+			const requests = 200
+			var wg sync.WaitGroup
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					valid := i%2 == 0
+					cpuRequest := "500m"
+					if !valid {
+						cpuRequest = "1cpu"
+					}
+					uid := fmt.Sprintf("review-%d", i)
+					request := httptest.NewRequest(http.MethodPost, WebhookValidatePath, bytes.NewReader(reviewOf(uid, cpuRequest)))
+					request.Header.Set("Content-Type", "application/json")
+					recorder := httptest.NewRecorder()
+					mockWebhookServer.ServeValidate(recorder, request)
+
+					review := admissionv1.AdmissionReview{}
+					Expect(json.Unmarshal(recorder.Body.Bytes(), &review)).To(Succeed())
+					Expect(review.Response.UID).To(Equal(types.UID(uid)))
+					Expect(review.Response.Allowed).To(Equal(valid))
+					if !valid {
+						Expect(review.Response.Result.Message).To(ContainSubstring("spec.targets[0].cpu_requests"))
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	})
+	Context("Webhook Metrics", func() {
+		mockWebhookServer := WebhookServer{
+			server: &http.Server{},
+		}
+		It("Should count a pod denied for missing resources", func() {
+			deniedPod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-metrics-no-resources",
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "test-without-resources",
+						},
+					},
+				},
+			}
+			output, err := json.Marshal(deniedPod)
+			Expect(err).NotTo(HaveOccurred())
+
+			body, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "admission.k8s.io/v1",
+					Kind:       "AdmissionReview",
+				},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       types.UID("metrics"),
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Operation: admissionv1.Create,
+					Object: runtime.RawExtension{
+						Raw: output,
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			denials := admissionRequestsTotal.WithLabelValues(webhookValidate, "Pod", "CREATE", "denied", denialMissingResources)
+			before := testutil.ToFloat64(denials)
+
+			request := httptest.NewRequest(http.MethodPost, WebhookValidatePath, bytes.NewReader(body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			mockWebhookServer.ServeValidate(recorder, request)
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(testutil.ToFloat64(denials)).To(Equal(before + 1))
+		})
 	})
 })
+
+var updateGolden = flag.Bool("update-golden", false, "Rewrite the golden files of the tests.")
+
+// quotaFieldNames are the shorthands of a target the mutating webhook defaults
+var quotaFieldNames = []string{"cpu_requests", "cpu_limits", "mem_requests", "mem_limits"}
+
+// missingFieldsEntries returns a table entry for every combination of missing shorthands in the version
+func missingFieldsEntries(version string) []TableEntry {
+	entries := []TableEntry{}
+	for mask := 0; mask < 1<<len(quotaFieldNames); mask++ {
+		missing := []string{}
+		for i, name := range quotaFieldNames {
+			if mask&(1<<i) != 0 {
+				missing = append(missing, name)
+			}
+		}
+		entries = append(entries, Entry(version+"/"+goldenName(missing), version, missing))
+	}
+	return entries
+}
+
+func goldenName(missing []string) string {
+	if len(missing) == 0 {
+		return "missing-none.json"
+	}
+	return "missing-" + strings.Join(missing, "-") + ".json"
+}
+
+// missingFieldsPatch mutates a ResourceLimiter of the version whose single target misses the fields,
+// it returns the object and the indented patch
+func missingFieldsPatch(version string, missing []string) ([]byte, []byte) {
+	fields := map[string]interface{}{
+		"name":         "default",
+		"cpu_requests": "500m",
+		"cpu_limits":   "1",
+		"mem_requests": "100Mi",
+		"mem_limits":   "200Mi",
+	}
+	for _, name := range missing {
+		delete(fields, name)
+	}
+	output, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "resources.resourcelimiter.io/" + version,
+		"kind":       "ResourceLimiter",
+		"metadata":   map[string]interface{}{"name": "test-missing-fields"},
+		"spec":       map[string]interface{}{"targets": []interface{}{fields}},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	mockWebhookServer := WebhookServer{}
+	response := mockWebhookServer.mutate(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Kind:   metav1.GroupVersionKind{Kind: "ResourceLimiter", Version: version},
+			Object: runtime.RawExtension{Raw: output},
+		},
+	})
+	Expect(response.Allowed).To(Equal(true))
+	patch := []patchOperation{}
+	if len(response.Patch) != 0 {
+		Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+	}
+	indented, err := json.MarshalIndent(patch, "", "  ")
+	Expect(err).NotTo(HaveOccurred())
+	return output, append(indented, '\n')
+}
+
+var withResources = corev1.ResourceRequirements{
+	Limits: corev1.ResourceList{
+		corev1.ResourceCPU:    k8sresource.MustParse("200m"),
+		corev1.ResourceMemory: k8sresource.MustParse("256Mi"),
+	},
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    k8sresource.MustParse("100m"),
+		corev1.ResourceMemory: k8sresource.MustParse("128Mi"),
+	},
+}
+
+// workloadOf wraps the pod spec into a workload of the kind
+func workloadOf(kind string, spec corev1.PodSpec) interface{} {
+	meta := metav1.ObjectMeta{Name: "test-" + strings.ToLower(kind)}
+	template := corev1.PodTemplateSpec{Spec: spec}
+	switch kind {
+	case "Pod":
+		return corev1.Pod{ObjectMeta: meta, Spec: spec}
+	case "Deployment":
+		return appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Template: template}}
+	case "StatefulSet":
+		return appsv1.StatefulSet{ObjectMeta: meta, Spec: appsv1.StatefulSetSpec{Template: template}}
+	case "DaemonSet":
+		return appsv1.DaemonSet{ObjectMeta: meta, Spec: appsv1.DaemonSetSpec{Template: template}}
+	case "ReplicaSet":
+		return appsv1.ReplicaSet{ObjectMeta: meta, Spec: appsv1.ReplicaSetSpec{Template: template}}
+	case "ReplicationController":
+		return corev1.ReplicationController{ObjectMeta: meta, Spec: corev1.ReplicationControllerSpec{Template: &template}}
+	case "Job":
+		return batchv1.Job{ObjectMeta: meta, Spec: batchv1.JobSpec{Template: template}}
+	case "CronJob":
+		return batchv1.CronJob{ObjectMeta: meta, Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template}}}}
+	}
+	return nil
+}
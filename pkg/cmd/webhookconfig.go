@@ -56,10 +56,53 @@ func createOrUpdateWebhookConfiguration(clientset *kubernetes.Clientset, caPEM *
 						},
 						Rule: admissionregistrationv1.Rule{
 							APIGroups:   []string{"resources.resourcelimiter.io"},
-							APIVersions: []string{"v1beta1", "v1beta2"},
+							APIVersions: []string{"v1beta1", "v1beta2", "v1"},
 							Resources:   []string{"resourcelimiters"},
 						},
 					},
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"replicationcontrollers"},
+						},
+					},
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{"apps"},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+						},
+					},
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{"batch"},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"jobs", "cronjobs"},
+						},
+					},
 				},
 				NamespaceSelector: &metav1.LabelSelector{
 					MatchLabels: map[string]string{
@@ -126,7 +169,7 @@ func createOrUpdateWebhookConfiguration(clientset *kubernetes.Clientset, caPEM *
 						},
 						Rule: admissionregistrationv1.Rule{
 							APIGroups:   []string{"resources.resourcelimiter.io"},
-							APIVersions: []string{"v1beta1", "v1beta2"},
+							APIVersions: []string{"v1beta1", "v1beta2", "v1"},
 							Resources:   []string{"resourcelimiters"},
 						},
 					},
@@ -138,7 +181,17 @@ func createOrUpdateWebhookConfiguration(clientset *kubernetes.Clientset, caPEM *
 						Rule: admissionregistrationv1.Rule{
 							APIGroups:   []string{""},
 							APIVersions: []string{"v1"},
-							Resources:   []string{"pods"},
+							Resources:   []string{"pods", "replicationcontrollers"},
+						},
+					},
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods/ephemeralcontainers"},
 						},
 					},
 					{
@@ -149,7 +202,18 @@ func createOrUpdateWebhookConfiguration(clientset *kubernetes.Clientset, caPEM *
 						Rule: admissionregistrationv1.Rule{
 							APIGroups:   []string{"apps", "extensions"},
 							APIVersions: []string{"v1"},
-							Resources:   []string{"deployments", "daemonsets"},
+							Resources:   []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+						},
+					},
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{"batch"},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"jobs", "cronjobs"},
 						},
 					},
 				},
@@ -174,7 +238,7 @@ func createOrUpdateWebhookConfiguration(clientset *kubernetes.Clientset, caPEM *
 		} else {
 			// there is an existing validatingWebhookConfiguration
 			if len(foundWebhookConfig.Webhooks) != len(validatingWebhookConfig.Webhooks) ||
-				!(foundWebhookConfig.Webhooks[0].Name == mutatingWebhookConfig.Webhooks[0].Name &&
+				!(foundWebhookConfig.Webhooks[0].Name == validatingWebhookConfig.Webhooks[0].Name &&
 					reflect.DeepEqual(foundWebhookConfig.Webhooks[0].AdmissionReviewVersions, validatingWebhookConfig.Webhooks[0].AdmissionReviewVersions) &&
 					reflect.DeepEqual(foundWebhookConfig.Webhooks[0].SideEffects, validatingWebhookConfig.Webhooks[0].SideEffects) &&
 					reflect.DeepEqual(foundWebhookConfig.Webhooks[0].FailurePolicy, validatingWebhookConfig.Webhooks[0].FailurePolicy) &&
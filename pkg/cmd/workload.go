@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/conflict"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// isWorkload reports whether the kind carries a pod spec the webhooks check
+func isWorkload(kind string) bool {
+	switch kind {
+	case "Pod", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job", "CronJob":
+		return true
+	}
+	return false
+}
+
+// podTemplate is the pod spec of a workload, with the JSON pointer to it
+type podTemplate struct {
+	name string
+	path string
+	spec *corev1.PodSpec
+	// sidecars holds the indexes of the restartable init containers
+	sidecars map[int]bool
+	// replicas is the number of pods the workload runs, 0 when it depends on the nodes as for a DaemonSet;
+	// surge is the number of extra pods a rolling update may start
+	replicas, surge int64
+}
+
+// replicasOf defaults an unset replica count to one, as the API server does
+func replicasOf(replicas *int32) int64 {
+	if replicas == nil {
+		return 1
+	}
+	return int64(*replicas)
+}
+
+// podTemplateOf decodes a workload of the kind and extracts its pod spec
+func podTemplateOf(kind string, raw []byte) (*podTemplate, error) {
+	template, err := decodePodTemplate(kind, raw)
+	if err != nil {
+		return nil, err
+	}
+	if template.sidecars, err = restartableInitContainers(raw, template.path); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// restartableInitContainers returns the indexes of the init containers with restartPolicy Always.
+// The field is newer than the vendored API types, so it is read from the raw object.
+func restartableInitContainers(raw []byte, path string) (map[int]bool, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	for _, field := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		next, ok := obj[field].(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		obj = next
+	}
+	initContainers, _ := obj["initContainers"].([]interface{})
+	sidecars := map[int]bool{}
+	for i, item := range initContainers {
+		if container, ok := item.(map[string]interface{}); ok && container["restartPolicy"] == string(corev1.RestartPolicyAlways) {
+			sidecars[i] = true
+		}
+	}
+	return sidecars, nil
+}
+
+func decodePodTemplate(kind string, raw []byte) (*podTemplate, error) {
+	switch kind {
+	case "Pod":
+		var pod corev1.Pod
+		if err := json.Unmarshal(raw, &pod); err != nil {
+			return nil, err
+		}
+		return &podTemplate{name: pod.Name, path: "/spec", spec: &pod.Spec, replicas: 1}, nil
+	case "Deployment":
+		var deployment appsv1.Deployment
+		if err := json.Unmarshal(raw, &deployment); err != nil {
+			return nil, err
+		}
+		template := &podTemplate{name: deployment.Name, path: "/spec/template/spec", spec: &deployment.Spec.Template.Spec, replicas: replicasOf(deployment.Spec.Replicas)}
+		if deployment.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+			// The API server defaults maxSurge to 25%, rounded up
+			maxSurge := intstr.FromString("25%")
+			if deployment.Spec.Strategy.RollingUpdate != nil && deployment.Spec.Strategy.RollingUpdate.MaxSurge != nil {
+				maxSurge = *deployment.Spec.Strategy.RollingUpdate.MaxSurge
+			}
+			surge, err := intstr.GetScaledValueFromIntOrPercent(&maxSurge, int(template.replicas), true)
+			if err != nil {
+				return nil, err
+			}
+			template.surge = int64(surge)
+		}
+		return template, nil
+	case "StatefulSet":
+		var statefulset appsv1.StatefulSet
+		if err := json.Unmarshal(raw, &statefulset); err != nil {
+			return nil, err
+		}
+		return &podTemplate{name: statefulset.Name, path: "/spec/template/spec", spec: &statefulset.Spec.Template.Spec, replicas: replicasOf(statefulset.Spec.Replicas)}, nil
+	case "DaemonSet":
+		var daemonset appsv1.DaemonSet
+		if err := json.Unmarshal(raw, &daemonset); err != nil {
+			return nil, err
+		}
+		return &podTemplate{name: daemonset.Name, path: "/spec/template/spec", spec: &daemonset.Spec.Template.Spec}, nil
+	case "ReplicaSet":
+		var replicaset appsv1.ReplicaSet
+		if err := json.Unmarshal(raw, &replicaset); err != nil {
+			return nil, err
+		}
+		return &podTemplate{name: replicaset.Name, path: "/spec/template/spec", spec: &replicaset.Spec.Template.Spec, replicas: replicasOf(replicaset.Spec.Replicas)}, nil
+	case "ReplicationController":
+		var controller corev1.ReplicationController
+		if err := json.Unmarshal(raw, &controller); err != nil {
+			return nil, err
+		}
+		if controller.Spec.Template == nil {
+			return &podTemplate{name: controller.Name, path: "/spec/template/spec", spec: &corev1.PodSpec{}}, nil
+		}
+		return &podTemplate{name: controller.Name, path: "/spec/template/spec", spec: &controller.Spec.Template.Spec, replicas: replicasOf(controller.Spec.Replicas)}, nil
+	case "Job":
+		var job batchv1.Job
+		if err := json.Unmarshal(raw, &job); err != nil {
+			return nil, err
+		}
+		return &podTemplate{name: job.Name, path: "/spec/template/spec", spec: &job.Spec.Template.Spec, replicas: replicasOf(job.Spec.Parallelism)}, nil
+	case "CronJob":
+		var cronjob batchv1.CronJob
+		if err := json.Unmarshal(raw, &cronjob); err != nil {
+			return nil, err
+		}
+		return &podTemplate{name: cronjob.Name, path: "/spec/jobTemplate/spec/template/spec", spec: &cronjob.Spec.JobTemplate.Spec.Template.Spec, replicas: replicasOf(cronjob.Spec.JobTemplate.Spec.Parallelism)}, nil
+	}
+	return nil, fmt.Errorf("unsupported kind %s", kind)
+}
+
+// namespaceQuota returns the target of the highest ranked applied ResourceLimiter targeting the namespace
+// among those the filter accepts, nil when there are none
+func (whsvr *WebhookServer) namespaceQuota(ctx context.Context, ns string, filter func(rlv1beta2.ResourceLimiterQuota) bool) (*rlv1beta2.ResourceLimiterQuota, error) {
+	if whsvr.client == nil {
+		return nil, nil
+	}
+	namespace := corev1.Namespace{}
+	if err := whsvr.client.Get(ctx, k8stypes.NamespacedName{Name: ns}, &namespace); err != nil {
+		return nil, err
+	}
+	rls := rlv1beta2.ResourceLimiterList{}
+	if err := whsvr.client.List(ctx, &rls); err != nil {
+		return nil, err
+	}
+
+	var (
+		owner *rlv1beta2.ResourceLimiter
+		found *rlv1beta2.ResourceLimiterQuota
+	)
+	for i := range rls.Items {
+		rl := &rls.Items[i]
+		if !rl.Spec.Applied || !rl.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for j := range rl.Spec.Quotas {
+			quota := &rl.Spec.Quotas[j]
+			if !filter(*quota) || !targets(*quota, &namespace) {
+				continue
+			}
+			if owner == nil || conflict.Outranks(rl, owner) {
+				owner, found = rl, quota
+			}
+			break
+		}
+	}
+	return found, nil
+}
+
+// containerDefaults returns the container defaults the highest ranked applied ResourceLimiter
+// targeting the namespace declares, nil when there are none
+func (whsvr *WebhookServer) containerDefaults(ctx context.Context, ns string) (*corev1.ResourceRequirements, error) {
+	quota, err := whsvr.namespaceQuota(ctx, ns, func(quota rlv1beta2.ResourceLimiterQuota) bool { return quota.ContainerDefaults != nil })
+	if err != nil || quota == nil {
+		return nil, err
+	}
+	return quota.ContainerDefaults, nil
+}
+
+// targets reports whether the quota targets the namespace by name or by namespaceSelector
+func targets(quota rlv1beta2.ResourceLimiterQuota, namespace *corev1.Namespace) bool {
+	if quota.NamespaceName == namespace.Name {
+		return true
+	}
+	if quota.NamespaceSelector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(quota.NamespaceSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(namespace.Labels))
+}
+
+// escapePointer escapes a resource name for a JSON pointer, e.g. nvidia.com/gpu
+func escapePointer(name corev1.ResourceName) string {
+	return strings.ReplaceAll(strings.ReplaceAll(string(name), "~", "~0"), "/", "~1")
+}
+
+// defaultResources returns the patch filling the requests and limits missing from the containers.
+// A defaulted request never exceeds the limit of the container and a defaulted limit is never below its request.
+func defaultResources(path string, containers []corev1.Container, defaults *corev1.ResourceRequirements) []patchOperation {
+	var patch []patchOperation
+	for i, container := range containers {
+		requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+		for name, value := range defaults.Requests {
+			if _, ok := container.Resources.Requests[name]; ok {
+				continue
+			}
+			if limit, ok := container.Resources.Limits[name]; ok && limit.Cmp(value) < 0 {
+				value = limit
+			}
+			requests[name] = value.DeepCopy()
+		}
+		for name, value := range defaults.Limits {
+			if _, ok := container.Resources.Limits[name]; ok {
+				continue
+			}
+			request, ok := container.Resources.Requests[name]
+			if !ok {
+				request, ok = requests[name]
+			}
+			if ok && request.Cmp(value) > 0 {
+				value = request
+			}
+			limits[name] = value.DeepCopy()
+		}
+
+		resourcesPath := fmt.Sprintf("%s/%d/resources", path, i)
+		if len(container.Resources.Requests) == 0 && len(container.Resources.Limits) == 0 {
+			if len(requests) == 0 && len(limits) == 0 {
+				continue
+			}
+			value := map[string]corev1.ResourceList{}
+			if len(requests) > 0 {
+				value["requests"] = requests
+			}
+			if len(limits) > 0 {
+				value["limits"] = limits
+			}
+			patch = append(patch, patchOperation{Op: "add", Path: resourcesPath, Value: value})
+			continue
+		}
+		patch = append(patch, resourceListPatch(resourcesPath+"/requests", container.Resources.Requests, requests)...)
+		patch = append(patch, resourceListPatch(resourcesPath+"/limits", container.Resources.Limits, limits)...)
+	}
+	return patch
+}
+
+// resourceListPatch adds the missing resources to a list, or the whole list when it is absent
+func resourceListPatch(path string, current, missing corev1.ResourceList) []patchOperation {
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(current) == 0 {
+		return []patchOperation{{Op: "add", Path: path, Value: missing}}
+	}
+	var patch []patchOperation
+	for _, name := range sortedNames(missing) {
+		value := missing[name]
+		patch = append(patch, patchOperation{Op: "add", Path: path + "/" + escapePointer(name), Value: value.DeepCopy()})
+	}
+	return patch
+}
+
+func sortedNames(list corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// mutateWorkload fills the resources missing from the containers of a workload with the defaults of its namespace
+func (whsvr *WebhookServer) mutateWorkload(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	template, err := podTemplateOf(req.Kind.Kind, req.Object.Raw)
+	if err != nil {
+		warningLogger.Printf("Could not unmarshal raw object into %s: %v", req.Kind.Kind, err)
+		return denied(denialDecodeFailure, err.Error())
+	}
+	infoLogger.Printf("Mutate AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v patchOperation=%v UserInfo=%v",
+		req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
+
+	defaults, err := whsvr.containerDefaults(context.Background(), req.Namespace)
+	if err != nil {
+		warningLogger.Printf("Could not look up the container defaults of namespace %s: %v", req.Namespace, err)
+		return denied(denialLookupFailed, err.Error())
+	}
+	if defaults == nil {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	patch := defaultResources(template.path+"/initContainers", template.spec.InitContainers, defaults)
+	patch = append(patch, defaultResources(template.path+"/containers", template.spec.Containers, defaults)...)
+	return patchResponse(patch)
+}
+
+// validateWorkload denies a workload whose containers or init containers do not set both limits and requests
+// or break the resource policy of the namespace, and reports the effective footprint of its pods
+func (whsvr *WebhookServer) validateWorkload(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	kind := strings.ToLower(req.Kind.Kind)
+	infoLogger.Printf("begin marshal %s %s", kind, req.Name)
+	template, err := podTemplateOf(req.Kind.Kind, req.Object.Raw)
+	if err != nil {
+		warningLogger.Printf("Could not unmarshal raw object into %s: %v", kind, err)
+		return denied(denialDecodeFailure, err.Error())
+	}
+	infoLogger.Printf("Validate AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v patchOperation=%v UserInfo=%v",
+		req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
+
+	specPath := fieldPath(template.path)
+	groupKind := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+	if req.SubResource == "ephemeralcontainers" {
+		// Ephemeral containers can not declare resources, they run within the footprint of the pod,
+		// so only the quantities they may carry are checked
+		errs := &admissionErrors{}
+		for i, cont := range template.spec.EphemeralContainers {
+			errs.add(denialInvalidQuantity, validateResources(cont.Resources, specPath.Child("ephemeralContainers").Index(i).Child("resources"))...)
+		}
+		if response := errs.deny(groupKind, template.name); response != nil {
+			return response
+		}
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("Validate ephemeral containers of %s OK", template.name),
+			},
+		}
+	}
+
+	// Every container missing its requests or limits is reported before the quantities
+	errs := &admissionErrors{}
+	for i, cont := range template.spec.InitContainers {
+		if len(cont.Resources.Limits) == 0 || len(cont.Resources.Requests) == 0 {
+			container := "init container"
+			if template.sidecars[i] {
+				container = "sidecar container"
+			}
+			errs.add(denialMissingResources, field.Required(specPath.Child("initContainers").Index(i).Child("resources"),
+				fmt.Sprintf("the %s %s of %s %s should set resources limits and requests", container, cont.Name, kind, template.name)))
+		}
+	}
+	for i, cont := range template.spec.Containers {
+		if len(cont.Resources.Limits) == 0 || len(cont.Resources.Requests) == 0 {
+			errs.add(denialMissingResources, field.Required(specPath.Child("containers").Index(i).Child("resources"),
+				fmt.Sprintf("the container %s of %s %s should set resources limits and requests", cont.Name, kind, template.name)))
+		}
+	}
+	for i, cont := range template.spec.InitContainers {
+		errs.add(denialInvalidQuantity, validateResources(cont.Resources, specPath.Child("initContainers").Index(i).Child("resources"))...)
+	}
+	for i, cont := range template.spec.Containers {
+		errs.add(denialInvalidQuantity, validateResources(cont.Resources, specPath.Child("containers").Index(i).Child("resources"))...)
+	}
+	if response := errs.deny(groupKind, template.name); response != nil {
+		warningLogger.Printf("failed to validate the resources of %s %s: %s", kind, template.name, response.Result.Message)
+		return response
+	}
+
+	requests, limits := podFootprint(template)
+	infoLogger.Printf("%s %s pods request %s and are limited to %s", kind, template.name, formatResources(requests), formatResources(limits))
+	policy, err := whsvr.resourcePolicy(context.Background(), req.Namespace)
+	if err != nil {
+		warningLogger.Printf("Could not look up the resource policy of namespace %s: %v", req.Namespace, err)
+		return denied(denialLookupFailed, err.Error())
+	}
+	if violation := policyViolation(policy, template, requests, limits); violation != "" {
+		return denied(denialPolicyViolation, fmt.Sprintf("failed to validate %s %s: %s", kind, template.name, violation))
+	}
+	if response := whsvr.admitQuota(req, template); response != nil {
+		return response
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Result: &metav1.Status{
+			Message: fmt.Sprintf("Validate %s of %s OK, pods request %s and are limited to %s", req.Name, req.Kind.Kind, formatResources(requests), formatResources(limits)),
+		},
+	}
+}
+
+// fieldPath turns the JSON pointer of a pod spec into a field path, e.g. spec.template.spec
+func fieldPath(pointer string) *field.Path {
+	parts := strings.Split(strings.Trim(pointer, "/"), "/")
+	return field.NewPath(parts[0], parts[1:]...)
+}
+
+// validateResources checks the requests and limits of a container are not negative
+// and that no request exceeds its limit
+func validateResources(resources corev1.ResourceRequirements, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, list := range []struct {
+		name      string
+		resources corev1.ResourceList
+	}{{"limits", resources.Limits}, {"requests", resources.Requests}} {
+		for _, name := range sortedNames(list.resources) {
+			if value := list.resources[name]; value.Sign() < 0 {
+				allErrs = append(allErrs, field.Invalid(path.Child(list.name).Key(string(name)), value.String(), "must not be negative"))
+			}
+		}
+	}
+	for _, name := range sortedNames(resources.Requests) {
+		request := resources.Requests[name]
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("requests").Key(string(name)), request.String(), fmt.Sprintf("must be less than or equal to the %s limit %s", name, limit.String())))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,85 @@
+package conflict
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
+	"github.com/chenliu1993/resourcelimiter/pkg/constants"
+)
+
+// Outranks reports whether a wins a namespace targeted by both a and b:
+// the highest priority wins, then the oldest, then the first name in alphabetical order
+func Outranks(a, b *rlv1beta2.ResourceLimiter) bool {
+	if a.Spec.Priority != b.Spec.Priority {
+		return a.Spec.Priority > b.Spec.Priority
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+// Namespaces returns the namespaces the ResourceLimiter targets by name or by namespaceSelector
+func Namespaces(ctx context.Context, c client.Reader, rl *rlv1beta2.ResourceLimiter) (map[string]bool, error) {
+	namespaces := map[string]bool{}
+	for i, quota := range rl.Spec.Quotas {
+		if quota.NamespaceName != "" {
+			namespaces[quota.NamespaceName] = true
+		}
+		if quota.NamespaceSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(quota.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector in targets[%d]: %v", i, err)
+		}
+		list := corev1.NamespaceList{}
+		if err := c.List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+		for _, ns := range list.Items {
+			if ns.DeletionTimestamp.IsZero() {
+				namespaces[ns.Name] = true
+			}
+		}
+	}
+	delete(namespaces, string(constants.IgnoreKubeSystem))
+	delete(namespaces, string(constants.IgnoreKubePublic))
+	return namespaces, nil
+}
+
+// Overlaps returns, for every given namespace also targeted by another applied ResourceLimiter,
+// the highest ranked of those other ResourceLimiters
+func Overlaps(ctx context.Context, c client.Reader, rl *rlv1beta2.ResourceLimiter, namespaces []string) (map[string]*rlv1beta2.ResourceLimiter, error) {
+	rls := rlv1beta2.ResourceLimiterList{}
+	if err := c.List(ctx, &rls); err != nil {
+		return nil, err
+	}
+
+	overlaps := map[string]*rlv1beta2.ResourceLimiter{}
+	for i := range rls.Items {
+		other := &rls.Items[i]
+		if other.Name == rl.Name || !other.Spec.Applied || !other.DeletionTimestamp.IsZero() {
+			continue
+		}
+		targeted, err := Namespaces(ctx, c, other)
+		if err != nil {
+			// A broken ResourceLimiter can not own any namespace
+			continue
+		}
+		for _, ns := range namespaces {
+			if !targeted[ns] {
+				continue
+			}
+			if current, ok := overlaps[ns]; !ok || Outranks(other, current) {
+				overlaps[ns] = other
+			}
+		}
+	}
+	return overlaps, nil
+}
@@ -23,6 +23,31 @@ const (
 	DefaultFinalizer       = "resourcelimiter.finalizer"
 	MutateNamespaceLabel   = "resourcelimiter-mutate"
 	ValidateNamespaceLabel = "resourcelimiter-validate"
+	// OwnerLabel is set on every managed resource quota to the name of its ResourceLimiter
+	OwnerLabel = "resourcelimiter.io/owner"
+	// LastAppliedHardAnnotation keeps the hard limits the controller wrote to a resource quota, to tell drift from spec changes
+	LastAppliedHardAnnotation = "resourcelimiter.io/last-applied-hard"
+)
+
+// Watermarks of a budget rebalance left unset, in percent of the share of a namespace
+const (
+	DefaultHighWatermark = 90
+	DefaultLowWatermark  = 50
+)
+
+// Event reasons recorded by the controller
+const (
+	EventReasonQuotaDrift        = "QuotaDrift"
+	EventReasonQuotaCreated      = "QuotaCreated"
+	EventReasonQuotaUpdated      = "QuotaUpdated"
+	EventReasonQuotaDeleted      = "QuotaDeleted"
+	EventReasonLimitRangeCreated = "LimitRangeCreated"
+	EventReasonLimitRangeUpdated = "LimitRangeUpdated"
+	EventReasonLimitRangeDeleted = "LimitRangeDeleted"
+	EventReasonLabelsRemoved     = "LabelsRemoved"
+	EventReasonFinalizerRemoved  = "FinalizerRemoved"
+	EventReasonBudgetDivided     = "BudgetDivided"
+	EventReasonBudgetRebalanced  = "BudgetRebalanced"
 )
 
 const (
@@ -31,6 +56,33 @@ const (
 	Stopped = "stopped"
 )
 
+// Condition types of ResourceLimiter status
+const (
+	ConditionReady            = "Ready"
+	ConditionReconciling      = "Reconciling"
+	ConditionDegraded         = "Degraded"
+	ConditionNamespaceMissing = "NamespaceMissing"
+	ConditionQuotaConflict    = "QuotaConflict"
+)
+
+// Condition reasons of ResourceLimiter status
+const (
+	ReasonQuotasApplied          = "QuotasApplied"
+	ReasonQuotasRemoved          = "QuotasRemoved"
+	ReasonReconcileComplete      = "ReconcileComplete"
+	ReasonReconcileFailed        = "ReconcileFailed"
+	ReasonRetrying               = "Retrying"
+	ReasonAllNamespacesFound     = "AllNamespacesFound"
+	ReasonNamespaceNotFound      = "NamespaceNotFound"
+	ReasonNamespaceLabelFailed   = "NamespaceLabelFailed"
+	ReasonInvalidQuota           = "InvalidQuota"
+	ReasonQuotaUpdateFailed      = "QuotaUpdateFailed"
+	ReasonLimitRangeUpdateFailed = "LimitRangeUpdateFailed"
+	ReasonNoConflict             = "NoConflict"
+	ReasonOutranked              = "Outranked"
+	ReasonBudgetPending          = "BudgetPending"
+)
+
 const (
 	ResourceLimiterApiVersion = "resources.resourcelimiter.io/v1beta1"
 	ResourceLimiterKind       = "ResourceLimiter"
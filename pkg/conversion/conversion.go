@@ -1,15 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
 	"github.com/munnerz/goautoneg"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -62,6 +66,27 @@ func convertV1beta2IntoV1beta1(newObject *rlv1beta2.ResourceLimiter) (*rlv1beta1
 	return oldObject, statusSucceed()
 }
 
+func convertV1IntoV1beta2(oldObject *rlapiv1.ResourceLimiter) (*rlv1beta2.ResourceLimiter, metav1.Status) {
+	infoLogger.Printf("begin converting v1 into v1beta2")
+	newObject := &rlv1beta2.ResourceLimiter{}
+
+	if err := oldObject.ConvertTo(newObject); err != nil {
+		return nil, statusErrorWithMessage("failed to convert from %q into %q: %v", v1APIVersion, v1beta2APIVersion, err)
+	}
+	return newObject, statusSucceed()
+}
+
+// convertV1beta2IntoV1 rejects string quotas which are not valid quantities
+func convertV1beta2IntoV1(oldObject *rlv1beta2.ResourceLimiter) (*rlapiv1.ResourceLimiter, metav1.Status) {
+	infoLogger.Printf("begin converting v1beta2 into v1")
+	newObject := &rlapiv1.ResourceLimiter{}
+
+	if err := newObject.ConvertFrom(oldObject); err != nil {
+		return nil, statusErrorWithMessage("failed to convert from %q into %q: %v", v1beta2APIVersion, v1APIVersion, err)
+	}
+	return newObject, statusSucceed()
+}
+
 func (whsvr *WebhookServer) serveConvert(w http.ResponseWriter, r *http.Request) {
 	var body []byte
 	if r.Body != nil {
@@ -181,93 +206,255 @@ func getOutputSerializer(accept string) runtime.Serializer {
 	return nil
 }
 
-// doConversion converts the requested object given the conversion function and returns a conversion response.
-// failures will be reported as Reason in the conversion response.
-func doConversion(convertRequest *v1beta1.ConversionRequest) *v1beta1.ConversionResponse {
-	var convertedObjects []runtime.RawExtension
-	switch convertRequest.DesiredAPIVersion {
-	case "resources.resourcelimiter.io/v1beta2":
-		for _, obj := range convertRequest.Objects {
-			unstructuredCR := &unstructured.Unstructured{}
-			if err := unstructuredCR.UnmarshalJSON(obj.Raw); err != nil {
-				klog.Error(err)
-				return conversionResponseFailureWithMessagef("failed to unmarshall object (%v) with error: %v", string(obj.Raw), err)
-			}
+// stringField returns the string value of an optional field of an unstructured object
+func stringField(obj map[string]interface{}, field string) string {
+	if value, ok := obj[field].(string); ok {
+		return value
+	}
+	return ""
+}
 
-			// This part is bound to how the v1beta1 is organized
-			specObject := unstructuredCR.Object["spec"].(map[string]interface{})
-			targets := []rlv1beta1.ResourceLimiterNamespace{}
-			for _, item := range specObject["targets"].([]interface{}) {
-				targets = append(targets, rlv1beta1.ResourceLimiterNamespace(item.(string)))
+// hardField parses the optional hard limits of an unstructured v1beta2 quota
+func hardField(obj map[string]interface{}) (corev1.ResourceList, error) {
+	hardMap, ok := obj["hard"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	hard := corev1.ResourceList{}
+	for name, value := range hardMap {
+		quantity, err := k8sresource.ParseQuantity(fmt.Sprint(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %v of hard %s: %v", value, name, err)
+		}
+		hard[corev1.ResourceName(name)] = quantity
+	}
+	return hard, nil
+}
+
+const (
+	v1beta1APIVersion = "resources.resourcelimiter.io/v1beta1"
+	v1beta2APIVersion = "resources.resourcelimiter.io/v1beta2"
+	v1APIVersion      = "resources.resourcelimiter.io/v1"
+)
+
+// unstructuredIntoV1beta1 is bound to how the v1beta1 is organized
+func unstructuredIntoV1beta1(unstructuredCR *unstructured.Unstructured) (*rlv1beta1.ResourceLimiter, error) {
+	specObject, _ := unstructuredCR.Object["spec"].(map[string]interface{})
+	targets := []rlv1beta1.ResourceLimiterNamespace{}
+	items, _ := specObject["targets"].([]interface{})
+	for _, item := range items {
+		targets = append(targets, rlv1beta1.ResourceLimiterNamespace(fmt.Sprint(item)))
+	}
+	types := map[rlv1beta1.ResourceLimiterType]string{}
+	typesMap, _ := specObject["types"].(map[string]interface{})
+	for k, v := range typesMap {
+		types[rlv1beta1.ResourceLimiterType(k)] = fmt.Sprint(v)
+	}
+	applied, _ := specObject["applied"].(bool)
+
+	return &rlv1beta1.ResourceLimiter{
+		Spec: rlv1beta1.ResourceLimiterSpec{
+			Applied: applied,
+			Targets: targets,
+			Types:   types,
+		},
+	}, nil
+}
+
+// unstructuredIntoV1beta2 is bound to how the v1beta2 is organized
+func unstructuredIntoV1beta2(unstructuredCR *unstructured.Unstructured) (*rlv1beta2.ResourceLimiter, error) {
+	specObject, _ := unstructuredCR.Object["spec"].(map[string]interface{})
+	quotas := []rlv1beta2.ResourceLimiterQuota{}
+	items, _ := specObject["targets"].([]interface{})
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid target %v", item)
+		}
+		hard, err := hardField(itemMap)
+		if err != nil {
+			return nil, err
+		}
+		quota := rlv1beta2.ResourceLimiterQuota{
+			NamespaceName: stringField(itemMap, "name"),
+			CpuRequest:    stringField(itemMap, "cpu_requests"),
+			CpuLimit:      stringField(itemMap, "cpu_limits"),
+			MemRequest:    stringField(itemMap, "mem_requests"),
+			MemLimit:      stringField(itemMap, "mem_limits"),
+			Hard:          hard,
+		}
+		if selector, ok := itemMap["namespaceSelector"].(map[string]interface{}); ok {
+			quota.NamespaceSelector = &metav1.LabelSelector{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selector, quota.NamespaceSelector); err != nil {
+				return nil, err
 			}
-			types := map[rlv1beta1.ResourceLimiterType]string{}
-			for k, v := range specObject["types"].(map[string]interface{}) {
-				types[rlv1beta1.ResourceLimiterType(k)] = v.(string)
+		}
+		if limitRange, ok := itemMap["limitRange"].(map[string]interface{}); ok {
+			quota.LimitRange = &corev1.LimitRangeSpec{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(limitRange, quota.LimitRange); err != nil {
+				return nil, err
 			}
-
-			cr := &rlv1beta1.ResourceLimiter{
-				Spec: rlv1beta1.ResourceLimiterSpec{
-					Applied: specObject["applied"].(bool),
-					Targets: targets,
-					Types:   types,
-				},
+		}
+		if defaults, ok := itemMap["containerDefaults"].(map[string]interface{}); ok {
+			quota.ContainerDefaults = &corev1.ResourceRequirements{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(defaults, quota.ContainerDefaults); err != nil {
+				return nil, err
 			}
-
-			newVer, status := convertV1beta1IntoV1beta2(cr)
-			if status.Status != metav1.StatusSuccess {
-				klog.Error(status.String())
-				return &v1beta1.ConversionResponse{
-					Result: status,
-				}
+		}
+		if policy, ok := itemMap["policy"].(map[string]interface{}); ok {
+			quota.Policy = &rlv1beta2.ResourcePolicy{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(policy, quota.Policy); err != nil {
+				return nil, err
 			}
-			convertedObjects = append(convertedObjects, runtime.RawExtension{Object: newVer})
+		}
+		quotas = append(quotas, quota)
+	}
+	applied, _ := specObject["applied"].(bool)
 
+	// Keep the status so that conditions survive reads through the other versions
+	status := rlv1beta2.ResourceLimiterStatus{}
+	if statusObject, ok := unstructuredCR.Object["status"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(statusObject, &status); err != nil {
+			return nil, err
 		}
-	case "resources.resourcelimiter.io/v1beta1":
-		for _, obj := range convertRequest.Objects {
-			unstructuredCR := &unstructured.Unstructured{}
-			if err := unstructuredCR.UnmarshalJSON(obj.Raw); err != nil {
-				klog.Error(err)
-				return conversionResponseFailureWithMessagef("failed to unmarshall object (%v) with error: %v", string(obj.Raw), err)
-			}
+	}
 
-			// This part is bound to how the v1beta2 is organized
-			specObject := unstructuredCR.Object["spec"].(map[string]interface{})
-			quotas := []rlv1beta2.ResourceLimiterQuota{}
-			for _, item := range specObject["targets"].([]interface{}) {
-				itemMap := item.(map[string]interface{})
-				quotas = append(quotas, rlv1beta2.ResourceLimiterQuota{
-					NamespaceName: itemMap["name"].(string),
-					CpuRequest:    itemMap["cpu_requests"].(string),
-					CpuLimit:      itemMap["cpu_limits"].(string),
-					MemRequest:    itemMap["mem_requests"].(string),
-					MemLimit:      itemMap["mem_limits"].(string),
-				})
-			}
+	priority, _, err := unstructured.NestedInt64(unstructuredCR.Object, "spec", "priority")
+	if err != nil {
+		return nil, err
+	}
+	var budget *rlv1beta2.ResourceBudget
+	if budgetObject, ok := specObject["budget"].(map[string]interface{}); ok {
+		budget = &rlv1beta2.ResourceBudget{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(budgetObject, budget); err != nil {
+			return nil, err
+		}
+	}
 
-			cr := &rlv1beta2.ResourceLimiter{
-				Spec: rlv1beta2.ResourceLimiterSpec{
-					Quotas:  quotas,
-					Applied: specObject["applied"].(bool),
-				},
-			}
+	var nodeSelector *metav1.LabelSelector
+	if selector, ok := specObject["nodeSelector"].(map[string]interface{}); ok {
+		nodeSelector = &metav1.LabelSelector{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selector, nodeSelector); err != nil {
+			return nil, err
+		}
+	}
 
-			oldVer, status := convertV1beta2IntoV1beta1(cr)
-			if status.Status != metav1.StatusSuccess {
-				klog.Error(status.String())
-				return &v1beta1.ConversionResponse{
-					Result: status,
-				}
-			}
-			convertedObjects = append(convertedObjects, runtime.RawExtension{Object: oldVer})
+	return &rlv1beta2.ResourceLimiter{
+		Spec: rlv1beta2.ResourceLimiterSpec{
+			Quotas:       quotas,
+			Applied:      applied,
+			Priority:     int32(priority),
+			DriftPolicy:  rlv1beta2.DriftPolicy(stringField(specObject, "driftPolicy")),
+			Budget:       budget,
+			NodeSelector: nodeSelector,
+		},
+		Status: status,
+	}, nil
+}
+
+// decodeIntoHub decodes an object of any served version into the v1beta2 hub.
+// Objects without apiVersion are taken as the other beta version, as the webhook always did.
+func decodeIntoHub(unstructuredCR *unstructured.Unstructured, raw []byte, desiredAPIVersion string) (*rlv1beta2.ResourceLimiter, metav1.Status) {
+	fromVersion := unstructuredCR.GetAPIVersion()
+	if fromVersion == "" {
+		fromVersion = v1beta1APIVersion
+		if desiredAPIVersion == v1beta1APIVersion {
+			fromVersion = v1beta2APIVersion
 		}
+	}
 
+	var (
+		hub    *rlv1beta2.ResourceLimiter
+		status metav1.Status
+	)
+	switch fromVersion {
+	case v1beta1APIVersion:
+		cr, err := unstructuredIntoV1beta1(unstructuredCR)
+		if err != nil {
+			return nil, statusErrorWithMessage("failed to decode %s object: %v", fromVersion, err)
+		}
+		if hub, status = convertV1beta1IntoV1beta2(cr); status.Status != metav1.StatusSuccess {
+			return nil, status
+		}
+	case v1beta2APIVersion:
+		cr, err := unstructuredIntoV1beta2(unstructuredCR)
+		if err != nil {
+			return nil, statusErrorWithMessage("failed to decode %s object: %v", fromVersion, err)
+		}
+		hub = cr
+	case v1APIVersion:
+		cr := &rlapiv1.ResourceLimiter{}
+		if err := json.Unmarshal(raw, cr); err != nil {
+			return nil, statusErrorWithMessage("failed to decode %s object: %v", fromVersion, err)
+		}
+		if hub, status = convertV1IntoV1beta2(cr); status.Status != metav1.StatusSuccess {
+			return nil, status
+		}
 	default:
-		return &v1beta1.ConversionResponse{
-			ConvertedObjects: convertedObjects,
-			Result:           statusErrorWithMessage("failed to do the conversion"),
+		return nil, statusErrorWithMessage("unsupported version %s", fromVersion)
+	}
+
+	// Conversion must never lose metadata
+	if metadata, ok := unstructuredCR.Object["metadata"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(metadata, &hub.ObjectMeta); err != nil {
+			return nil, statusErrorWithMessage("failed to decode metadata: %v", err)
+		}
+	}
+	return hub, statusSucceed()
+}
+
+// encodeFromHub converts the v1beta2 hub into the desired version
+func encodeFromHub(hub *rlv1beta2.ResourceLimiter, desiredAPIVersion string) (runtime.Object, metav1.Status) {
+	switch desiredAPIVersion {
+	case v1beta1APIVersion:
+		cr, status := convertV1beta2IntoV1beta1(hub)
+		if status.Status != metav1.StatusSuccess {
+			return nil, status
+		}
+		cr.ObjectMeta = hub.ObjectMeta
+		cr.TypeMeta = metav1.TypeMeta{APIVersion: desiredAPIVersion, Kind: "ResourceLimiter"}
+		return cr, status
+	case v1beta2APIVersion:
+		hub.TypeMeta = metav1.TypeMeta{APIVersion: desiredAPIVersion, Kind: "ResourceLimiter"}
+		return hub, statusSucceed()
+	case v1APIVersion:
+		cr, status := convertV1beta2IntoV1(hub)
+		if status.Status != metav1.StatusSuccess {
+			return nil, status
+		}
+		cr.TypeMeta = metav1.TypeMeta{APIVersion: desiredAPIVersion, Kind: "ResourceLimiter"}
+		return cr, status
+	}
+	return nil, statusErrorWithMessage("failed to do the conversion into %s", desiredAPIVersion)
+}
+
+// doConversion converts the requested object given the conversion function and returns a conversion response.
+// failures will be reported as Reason in the conversion response.
+func doConversion(convertRequest *v1beta1.ConversionRequest) *v1beta1.ConversionResponse {
+	var convertedObjects []runtime.RawExtension
+	for _, obj := range convertRequest.Objects {
+		unstructuredCR := &unstructured.Unstructured{}
+		if err := unstructuredCR.UnmarshalJSON(obj.Raw); err != nil {
+			klog.Error(err)
+			return conversionResponseFailureWithMessagef("failed to unmarshall object (%v) with error: %v", string(obj.Raw), err)
 		}
 
+		hub, status := decodeIntoHub(unstructuredCR, obj.Raw, convertRequest.DesiredAPIVersion)
+		if status.Status != metav1.StatusSuccess {
+			klog.Error(status.String())
+			return &v1beta1.ConversionResponse{
+				Result: status,
+			}
+		}
+
+		converted, status := encodeFromHub(hub, convertRequest.DesiredAPIVersion)
+		if status.Status != metav1.StatusSuccess {
+			klog.Error(status.String())
+			return &v1beta1.ConversionResponse{
+				Result: status,
+			}
+		}
+		convertedObjects = append(convertedObjects, runtime.RawExtension{Object: converted})
 	}
 
 	return &v1beta1.ConversionResponse{
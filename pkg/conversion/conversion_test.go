@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"reflect"
 
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -95,6 +99,36 @@ var _ = Describe("Tests for conversion webhooks", func() {
 			Expect(reflect.DeepEqual(response.ConvertedObjects[0].Object.(*rlv1beta2.ResourceLimiter), outputResourceLimiterV1beta2))
 		})
 
+		It("Should convert generic types into hard limits", func() {
+			inputResourceLimiterv1beta1 := rlv1beta1.ResourceLimiter{
+				Spec: rlv1beta1.ResourceLimiterSpec{
+					Applied: true,
+					Targets: []rlv1beta1.ResourceLimiterNamespace{
+						"default",
+					},
+					Types: map[rlv1beta1.ResourceLimiterType]string{
+						"limits.cpu":       "200m",
+						"requests.storage": "10Gi",
+						"count/pods":       "20",
+					},
+				},
+			}
+
+			outputResourceLimiterV1beta2 := &rlv1beta2.ResourceLimiter{}
+			Expect(inputResourceLimiterv1beta1.ConvertTo(outputResourceLimiterV1beta2)).To(Succeed())
+			Expect(len(outputResourceLimiterV1beta2.Spec.Quotas)).To(Equal(1))
+			Expect(outputResourceLimiterV1beta2.Spec.Quotas[0].CpuLimit).To(Equal("200m"))
+			Expect(outputResourceLimiterV1beta2.Spec.Quotas[0].Hard).To(Equal(corev1.ResourceList{
+				"requests.storage": k8sresource.MustParse("10Gi"),
+				"count/pods":       k8sresource.MustParse("20"),
+			}))
+
+			convertedBack := &rlv1beta1.ResourceLimiter{}
+			Expect(convertedBack.ConvertFrom(outputResourceLimiterV1beta2)).To(Succeed())
+			Expect(convertedBack.Spec.Types["requests.storage"]).To(Equal("10Gi"))
+			Expect(convertedBack.Spec.Types["count/pods"]).To(Equal("20"))
+		})
+
 		It("Should convert into v1beta1 successfully", func() {
 			inputResourceLimiterv1beta2 := rlv1beta2.ResourceLimiter{
 				TypeMeta: metav1.TypeMeta{
@@ -174,4 +208,136 @@ var _ = Describe("Tests for conversion webhooks", func() {
 			Expect(reflect.DeepEqual(response.ConvertedObjects[0].Object.(*rlv1beta1.ResourceLimiter), outputResourceLimiterV1beta1))
 		})
 	})
+	Context("Convert into v1", func() {
+		DescribeTable("Should reject unparsable quantities and percentages",
+			func(cpuLimit string) {
+				inputResourceLimiterV1beta2 := rlv1beta2.ResourceLimiter{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: "resources.resourcelimiter.io/v1beta2",
+						Kind:       "ResourceLimiter",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "resourcelimiter-v1beta2",
+					},
+					Spec: rlv1beta2.ResourceLimiterSpec{
+						Applied: true,
+						Quotas: []rlv1beta2.ResourceLimiterQuota{
+							{
+								NamespaceName: "default",
+								CpuRequest:    "150m",
+								CpuLimit:      cpuLimit,
+							},
+						},
+					},
+				}
+
+				output, err := json.Marshal(inputResourceLimiterV1beta2)
+				Expect(err).NotTo(HaveOccurred())
+
+				response := doConversion(&v1beta1.ConversionRequest{
+					DesiredAPIVersion: "resources.resourcelimiter.io/v1",
+					Objects: []runtime.RawExtension{
+						{
+							Raw: output,
+						},
+					},
+				})
+				Expect(response.Result.Status).To(Equal(metav1.StatusFailure))
+				Expect(response.Result.Message).To(ContainSubstring("spec.targets[0].cpu_limits: invalid quantity"))
+			},
+			Entry("a unit unknown to quantities", "1cpu"),
+			Entry("a percentage of no number", "abc%"),
+			Entry("a negative percentage", "-5%"),
+			Entry("a percentage above 100%", "150%"),
+		)
+
+		It("Should convert typed quantities into v1beta2", func() {
+			cpuLimit := k8sresource.MustParse("2")
+			inputResourceLimiterV1 := rlapiv1.ResourceLimiter{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "resources.resourcelimiter.io/v1",
+					Kind:       "ResourceLimiter",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "resourcelimiter-v1",
+				},
+				Spec: rlapiv1.ResourceLimiterSpec{
+					Applied: true,
+					Quotas: []rlapiv1.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							CpuLimit:      &cpuLimit,
+						},
+					},
+				},
+			}
+
+			output, err := json.Marshal(inputResourceLimiterV1)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := doConversion(&v1beta1.ConversionRequest{
+				DesiredAPIVersion: "resources.resourcelimiter.io/v1beta2",
+				Objects: []runtime.RawExtension{
+					{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Result.Status).To(Equal(metav1.StatusSuccess))
+			Expect(len(response.ConvertedObjects)).To(Equal(1))
+			converted := response.ConvertedObjects[0].Object.(*rlv1beta2.ResourceLimiter)
+			Expect(converted.Name).To(Equal("resourcelimiter-v1"))
+			Expect(converted.Spec.Quotas[0].CpuLimit).To(Equal("2"))
+		})
+
+		It("Should keep the percentages of v1beta2 apart from the quantities", func() {
+			inputResourceLimiterV1beta2 := rlv1beta2.ResourceLimiter{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "resources.resourcelimiter.io/v1beta2",
+					Kind:       "ResourceLimiter",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "resourcelimiter-percentages",
+				},
+				Spec: rlv1beta2.ResourceLimiterSpec{
+					Applied:      true,
+					NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "general"}},
+					Quotas: []rlv1beta2.ResourceLimiterQuota{
+						{
+							NamespaceName: "default",
+							CpuRequest:    "500m",
+							CpuLimit:      "15%",
+							MemLimit:      "12.5%",
+						},
+					},
+				},
+			}
+
+			output, err := json.Marshal(inputResourceLimiterV1beta2)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := doConversion(&v1beta1.ConversionRequest{
+				DesiredAPIVersion: "resources.resourcelimiter.io/v1",
+				Objects: []runtime.RawExtension{
+					{
+						Raw: output,
+					},
+				},
+			})
+			Expect(response.Result.Status).To(Equal(metav1.StatusSuccess))
+			Expect(len(response.ConvertedObjects)).To(Equal(1))
+			converted := response.ConvertedObjects[0].Object.(*rlapiv1.ResourceLimiter)
+			Expect(converted.Spec.NodeSelector.MatchLabels).To(Equal(map[string]string{"pool": "general"}))
+			quota := converted.Spec.Quotas[0]
+			Expect(quota.CpuRequest.String()).To(Equal("500m"))
+			Expect(quota.CpuLimit).To(BeNil())
+			Expect(quota.Percentages).To(Equal(&rlapiv1.ResourcePercentages{CpuLimit: "15%", MemLimit: "12.5%"}))
+
+			hub := &rlv1beta2.ResourceLimiter{}
+			Expect(converted.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Spec.Quotas[0].CpuLimit).To(Equal("15%"))
+			Expect(hub.Spec.Quotas[0].MemLimit).To(Equal("12.5%"))
+			Expect(hub.Spec.Quotas[0].CpuRequest).To(Equal("500m"))
+		})
+	})
 })
@@ -12,6 +12,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	rlapiv1 "github.com/chenliu1993/resourcelimiter/api/v1"
 	rlv1beta1 "github.com/chenliu1993/resourcelimiter/api/v1beta1"
 	rlv1beta2 "github.com/chenliu1993/resourcelimiter/api/v1beta2"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
@@ -41,6 +42,7 @@ func init() {
 	_ = v1.AddToScheme(runtimeScheme)
 	_ = rlv1beta1.AddToScheme(runtimeScheme)
 	_ = rlv1beta2.AddToScheme(runtimeScheme)
+	_ = rlapiv1.AddToScheme(runtimeScheme)
 }
 
 func main() {